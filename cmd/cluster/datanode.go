@@ -1,27 +1,21 @@
 package main
 
 import (
-	"bytes"
-	"encoding/binary"
-	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"net"
-	"os"
 	"sync"
 	"time"
 
-	"github.com/Jeffail/tunny"
 	"github.com/hn275/distributed-storage/internal"
-	"github.com/hn275/distributed-storage/internal/crypto"
-	"github.com/hn275/distributed-storage/internal/database"
 	"github.com/hn275/distributed-storage/internal/network"
+	"github.com/hn275/distributed-storage/internal/qos"
 	"github.com/hn275/distributed-storage/internal/telemetry"
+	"github.com/hn275/distributed-storage/internal/transport"
 )
 
-const randomLocalPort = "127.0.0.1:0"
-
 type dataNode struct {
 	net.Conn
 
@@ -29,16 +23,23 @@ type dataNode struct {
 	avgRT         float64       // average response time, in nanoseconds
 	requestCtr    uint64        // num of requests served
 	overHeadParam time.Duration // overhead in ms seconds, this is for sleep
+	heartbeat     time.Duration // interval of the heartbeats, none if 0
+
+	log   *slog.Logger
+	tel   *telemetry.Telemetry
+	mtx   *sync.Mutex
+	sched *scheduler
+	users net.Listener // redirect mode only
 
-	log  *slog.Logger
-	tel  *telemetry.Telemetry
-	mtx  *sync.Mutex
-	pool *tunny.Pool
+	tr *transport.Transport
+	// role of the peer dialed back, the user or the LB's relay
+	dialPeer string
 }
 
 type request struct {
-	msg       []byte
+	msg       *network.UserNodeJoinMsg
 	timeStart time.Time
+	user      net.Conn // the user dialed in, redirect mode only
 }
 
 func (d *dataNode) Write(buf []byte) (int, error) {
@@ -50,28 +51,48 @@ func (d *dataNode) Write(buf []byte) (int, error) {
 
 func nodeInitialize(
 	lbAddr string, nodeID uint16, t *telemetry.Telemetry,
-	overHeadParam time.Duration, capacity uint16,
+	overHeadParam time.Duration, capacity, weight uint16, sched qos.Config, queueSize int,
+	listenAddr string, tr *transport.Transport, dialPeer string, heartbeat time.Duration,
 ) (*dataNode, error) {
-	laddr, err := net.ResolveTCPAddr(network.ProtoTcp4, randomLocalPort)
-	if err != nil {
-		return nil, err
-	}
+	// in redirect mode the users dial the node, on the address advertised
+	var (
+		users net.Listener
+		addr  *net.TCPAddr
+		err   error
+	)
 
-	raddr, err := net.ResolveTCPAddr(network.ProtoTcp4, lbAddr)
-	if err != nil {
-		return nil, err
+	if listenAddr != "" {
+		ln, err := net.Listen(network.ProtoTcp, listenAddr)
+		if err != nil {
+			return nil, err
+		}
+		users = tr.Listen(ln)
+		addr = users.Addr().(*net.TCPAddr)
 	}
 
+	initialized := false
+	defer func() {
+		if !initialized && users != nil {
+			users.Close()
+		}
+	}()
+
 	// dial and ping LB, notifying node type
-	lbSoc, err := net.DialTCP(network.ProtoTcp4, laddr, raddr)
+	lbSoc, err := tr.Dial(lbAddr, transport.RoleLB)
 	if err != nil {
 		return nil, err
 	}
 
-	ping := [3]byte{network.DataNodeJoin}
-	network.BinaryEndianess.PutUint16(ping[1:], nodeID)
+	ping := &network.DataNodeJoinMsg{
+		NodeID:    nodeID,
+		Weight:    weight,
+		Capacity:  capacity,
+		Heartbeat: heartbeat,
+		Addr:      addr,
+	}
 
-	if _, err := lbSoc.Write(ping[:]); err != nil {
+	if err := network.Send(lbSoc, ping); err != nil {
+		lbSoc.Close()
 		return nil, err
 	}
 
@@ -84,17 +105,25 @@ func nodeInitialize(
 		avgRT:         0.0,
 		requestCtr:    0,
 		overHeadParam: overHeadParam,
+		heartbeat:     heartbeat,
+
+		log:   logger,
+		tel:   t,
+		mtx:   new(sync.Mutex),
+		sched: nil,
+		users: users,
 
-		log:  logger,
-		tel:  t,
-		mtx:  new(sync.Mutex),
-		pool: nil,
+		tr:       tr,
+		dialPeer: dialPeer,
 	}
 
-	dataNode.pool = tunny.NewFunc(int(capacity), func(i any) any {
-		return dataNode.handleUserJoin(i.(*request))
-	})
+	dataNode.sched, err = newScheduler(int(capacity), sched, queueSize, dataNode.serve)
+	if err != nil {
+		lbSoc.Close()
+		return nil, err
+	}
 
+	initialized = true
 	return dataNode, nil
 
 }
@@ -102,7 +131,7 @@ func nodeInitialize(
 func (d *dataNode) Listen() {
 	defer wg.Done()
 	defer d.Close()
-	defer d.pool.Close()
+	defer d.sched.close()
 
 	d.tel.Collect(&event{
 		nodeID:       d.id,
@@ -121,10 +150,20 @@ func (d *dataNode) Listen() {
 		"addr", d.LocalAddr(),
 	)
 
+	if d.users != nil {
+		defer d.users.Close()
+		go d.acceptUsers()
+	}
+
+	if d.heartbeat > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		go d.sendHeartbeats(stop)
+	}
+
 	for {
-		buf := make([]byte, 16)
 		// get a request from LB
-		_, err := d.Read(buf)
+		msg, err := network.Receive(d)
 		if err != nil {
 			if errors.Is(err, io.EOF) {
 				d.log.Info("load balancer disconnected.")
@@ -136,33 +175,17 @@ func (d *dataNode) Listen() {
 			return
 		}
 
-		switch buf[0] {
-		case network.UserNodeJoin:
+		switch msg := msg.(type) {
+		case *network.UserNodeJoinMsg:
 			wg.Add(1)
-			go func() {
-				defer wg.Done()
-
-				req := &request{buf, time.Now()}
-				if err := d.pool.Process(req); err != nil {
-					d.log.Error("failed to service request.", "err", err.(error))
-				}
-
-				d.tel.Collect(&event{
-					nodeID:       d.id,
-					nodeOverhead: d.overHeadParam,
-					eventType:    eventRequestRecv,
-					peer:         "",
-					timestamp:    req.timeStart,
-					duration:     0,
-					size:         0,
-					avgRT:        d.avgRT,
-					activeReq:    d.requestCtr,
-				})
-			}()
+			req := &request{msg, time.Now(), nil}
+			if !d.sched.submit(req) {
+				go d.reject(req)
+			}
 
 		default:
 			d.log.Error("invalid requested service type.",
-				"request", buf[0])
+				"request", msg.Type())
 
 		}
 	}
@@ -180,25 +203,132 @@ func (d *dataNode) Listen() {
 	})
 }
 
-func (d *dataNode) handleUserJoin(req *request) error {
-	if len(req.msg) != 16 {
-		panic("handleUserJoin invalid buf size")
+// tells the LB the node is alive every heartbeat interval, until `stop` is
+// closed.
+func (d *dataNode) sendHeartbeats(stop <-chan struct{}) {
+	ticker := time.NewTicker(d.heartbeat)
+	defer ticker.Stop()
+
+	for seq := uint64(0); ; seq++ {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		if err := network.Send(d, &network.HeartbeatMsg{Seq: seq}); err != nil {
+			d.log.Error("failed to send heartbeat.", "err", err)
+			return
+		}
 	}
+}
 
-	buf, ts := req.msg, &req.timeStart
-	defer d.healthCheckReport(ts)
+// takes the requests of the users redirected by the LB, until the listener is
+// closed.
+func (d *dataNode) acceptUsers() {
+	for {
+		user, err := d.users.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			d.log.Error("failed to accept user.", "err", err)
+			continue
+		}
 
-	time.Sleep(d.overHeadParam)
+		go d.handleRedirect(user)
+	}
+}
 
-	// dial user's listener
-	userAddr, err := network.BytesToAddr(buf[1:7])
-	if err != nil {
-		return err
+// the user sends the request the LB would have forwarded in direct mode.
+func (d *dataNode) handleRedirect(user net.Conn) {
+	if err := d.tr.Authorize(user, transport.RoleUser); err != nil {
+		d.log.Error("user not authorized.", "addr", user.RemoteAddr(), "err", err)
+		user.Close()
+		return
 	}
 
-	user, err := net.DialTCP(network.ProtoTcp4, nil, userAddr.(*net.TCPAddr))
+	msg, err := network.Receive(user)
 	if err != nil {
-		return err
+		d.log.Error("failed to read from user.", "err", err)
+		user.Close()
+		return
+	}
+
+	joinMsg, ok := msg.(*network.UserNodeJoinMsg)
+	if !ok {
+		d.log.Error("invalid requested service type.", "request", msg.Type())
+		user.Close()
+		return
+	}
+
+	wg.Add(1)
+	req := &request{joinMsg, time.Now(), user}
+	if !d.sched.submit(req) {
+		go d.reject(req)
+	}
+}
+
+// runs on the scheduler's workers.
+func (d *dataNode) serve(req *request) {
+	defer wg.Done()
+
+	if err := d.handleUserJoin(req, false); err != nil {
+		d.log.Error("failed to service request.", "err", err)
+	}
+
+	d.tel.Collect(&event{
+		nodeID:       d.id,
+		nodeOverhead: d.overHeadParam,
+		eventType:    eventRequestRecv,
+		peer:         "",
+		timestamp:    req.timeStart,
+		duration:     0,
+		size:         0,
+		avgRT:        d.avgRT,
+		activeReq:    d.requestCtr,
+	})
+}
+
+// turns down a request the scheduler has no room for.
+func (d *dataNode) reject(req *request) {
+	defer wg.Done()
+
+	if err := d.handleUserJoin(req, true); err != nil {
+		d.log.Warn("request rejected.", "err", err)
+	}
+
+	d.tel.Collect(&event{
+		nodeID:       d.id,
+		nodeOverhead: d.overHeadParam,
+		eventType:    eventRequestRejected,
+		peer:         peerUser,
+		timestamp:    req.timeStart,
+		duration:     uint64(time.Since(req.timeStart).Nanoseconds()),
+		size:         0,
+		avgRT:        d.avgRT,
+		activeReq:    d.requestCtr,
+	})
+}
+
+// serves the request, or only responds with a busy status if `busy`.
+func (d *dataNode) handleUserJoin(req *request, busy bool) error {
+	msg, ts := req.msg, &req.timeStart
+
+	status := uint8(network.StatusInternal)
+	defer func() { d.healthCheckReport(ts, msg.Digest, status) }()
+
+	if !busy {
+		time.Sleep(d.overHeadParam)
+	}
+
+	// dial user's listener, unless the user dialed in
+	user := req.user
+	if user == nil {
+		conn, err := net.DialTCP(network.ProtoTcp, nil, msg.Addr)
+		if err != nil {
+			return err
+		}
+		user = d.tr.Client(conn, d.dialPeer)
 	}
 
 	defer user.Close()
@@ -207,31 +337,22 @@ func (d *dataNode) handleUserJoin(req *request) error {
 	d.log.Info("connected to user.", "addr", user.RemoteAddr())
 
 	// get file digest + pub key from user
-	var fileBuf [64]byte
-	if _, err := user.Read(fileBuf[:]); err != nil {
-		return err
-	}
-
-	fileName := hex.EncodeToString(fileBuf[:32])
-	filePath := database.AccessCluster.Append(fileName).String()
-
-	// read + decrypt file
-	file, err := os.OpenFile(filePath, os.O_RDONLY, 0666)
+	fileReq, err := network.Receive(user)
 	if err != nil {
 		return err
 	}
 
-	var (
-		pubKey []byte = fileBuf[32:]
-		secKey []byte = crypto.DataNodeSecretKey[:]
-	)
+	fileMsg, ok := fileReq.(*network.FileRequestMsg)
+	if !ok {
+		return fmt.Errorf("unexpected message type from user: %d", fileReq.Type())
+	}
 
-	s, err := crypto.NewFileStream(secKey, pubKey)
-	if err != nil {
-		return err
+	if busy {
+		status = network.StatusBusy
+		return respondError(user, status, errNodeBusy)
 	}
 
-	n, err := s.DecryptAndCopy(user, file)
+	n, status, err := sendFile(user, fileMsg)
 	if err != nil {
 		return err
 	}
@@ -251,26 +372,36 @@ func (d *dataNode) handleUserJoin(req *request) error {
 	return nil
 }
 
-func (d *dataNode) healthCheckReport(srvStartTime *time.Time) {
+func (d *dataNode) healthCheckReport(
+	srvStartTime *time.Time, digest [32]byte, status uint8,
+) {
 	ts := time.Now()
 
-	// calculate the next average
+	// calculate the next average, over the requests served only
 	dur := float64(time.Since(*srvStartTime).Nanoseconds())
-	d.avgRT = internal.CalcMovingAvg(d.requestCtr, d.avgRT, dur)
-	d.requestCtr += 1
-
-	// sends health check packet to LB
-	buf := make([]byte, 16)
-	buf[0] = network.HealthCheck
+	if status == network.StatusOK {
+		d.avgRT = internal.CalcMovingAvg(d.requestCtr, d.avgRT, dur)
+		d.requestCtr += 1
+	}
 
-	bufWriter := bytes.NewBuffer(buf[1:1])
-	if err := binary.Write(bufWriter, network.BinaryEndianess, d.avgRT); err != nil {
-		panic(err) // TODO: log this
+	// sends health check packet to LB, with the raw latency of this request
+	frame, err := network.Encode(&network.HealthCheckMsg{
+		AvgRT:   d.avgRT,
+		Latency: time.Duration(dur),
+		Digest:  digest,
+		Status:  status,
+	})
+	if err != nil {
+		d.log.Error("failed to encode health check.", "err", err)
+		return
 	}
 
-	n, err := d.Write(buf)
+	// the LB dropped the node, closing the connection stops the node's loops
+	n, err := d.Write(frame)
 	if err != nil {
-		panic(err) // TODO: log this
+		d.log.Error("failed to send health check.", "err", err)
+		d.Close()
+		return
 	}
 
 	d.tel.Collect(&event{
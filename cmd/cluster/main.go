@@ -1,15 +1,13 @@
 package main
 
 import (
-	"fmt"
 	"log/slog"
-	"math/rand"
 	"sync"
-	"time"
 
 	"github.com/hn275/distributed-storage/internal"
 	"github.com/hn275/distributed-storage/internal/config"
 	"github.com/hn275/distributed-storage/internal/telemetry"
+	"github.com/hn275/distributed-storage/internal/transport"
 )
 
 var wg *sync.WaitGroup
@@ -29,10 +27,37 @@ func main() {
 		panic("invalid capacity")
 	}
 
+	heartbeat, err := conf.GetHeartbeat()
+	if err != nil {
+		panic(err)
+	}
+
 	expName := globConf.Experiment.Name
 
 	// parse load balancing address
-	lbNodeAddr := fmt.Sprintf("127.0.0.1:%d", globConf.LoadBalancer.LocalPort)
+	lbNodeAddr := globConf.LBAddr()
+
+	// the users only dial the data nodes in redirect mode
+	mode, err := globConf.LoadBalancer.GetMode()
+	if err != nil {
+		panic(err)
+	}
+
+	listenAddr := ""
+	if mode == config.ModeRedirect {
+		listenAddr = conf.ListenAddr()
+	}
+
+	tr, err := globConf.Security.NewTransport(transport.RoleNode)
+	if err != nil {
+		panic(err)
+	}
+
+	// in relay mode the data nodes dial the LB back, instead of the user
+	dialPeer := transport.RoleUser
+	if mode == config.ModeRelay {
+		dialPeer = transport.RoleLB
+	}
 
 	// telemetry
 	filePath := "tmp/output/cluster/cluster-" + expName + ".csv"
@@ -55,27 +80,17 @@ func main() {
 	for nodeID := uint16(0); nodeID < conf.Node; nodeID++ {
 		go func(nodeIndex uint16) {
 
-			overHeadParam := time.Duration(0)
+			overHeadParam := globConf.Experiment.GetOverhead(nodeIndex)
 			if !globConf.Experiment.Homogeneous {
-				rand.Seed(int64(nodeIndex))
-
-				// For the sake of replicability and to ensure nodes are "heterogeneous" enough,
-				// we keep the first 10 nodes hardcoded for the heterogeneous case.
-				// If you want to change this behaviour, you need to modify these if statements
-				if nodeIndex < 3 {
-					overHeadParam = time.Millisecond * (10)
-				} else if nodeIndex < 6 {
-					overHeadParam = time.Millisecond * (500)
-				} else if nodeIndex < 10 {
-					overHeadParam = time.Millisecond * (900)
-				} else {
-					overHeadParam = time.Millisecond * time.Duration(rand.Int63n(globConf.Experiment.OverheadParam))
-				}
-
 				slog.Info("sleep timer", "v", overHeadParam)
 			}
 
-			node, err := nodeInitialize(lbNodeAddr, nodeID, tel, overHeadParam, conf.Capacity)
+			node, err := nodeInitialize(
+				lbNodeAddr, nodeID, tel, overHeadParam,
+				conf.GetCapacity(nodeID), conf.GetWeight(nodeID),
+				conf.Scheduler, conf.GetQueueSize(), listenAddr, tr, dialPeer,
+				heartbeat,
+			)
 			if err != nil {
 				slog.Error(
 					"failed to initialize a data node.",
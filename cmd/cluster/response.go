@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/hn275/distributed-storage/internal/crypto"
+	"github.com/hn275/distributed-storage/internal/database"
+	"github.com/hn275/distributed-storage/internal/network"
+)
+
+var errNodeBusy = errors.New("no room in the scheduler queue")
+
+// writes the OK response header ahead of the first byte of content.
+type responseWriter struct {
+	io.Writer
+	length uint64
+	sent   bool
+}
+
+func (w *responseWriter) Write(buf []byte) (int, error) {
+	if err := w.flush(); err != nil {
+		return 0, err
+	}
+	return w.Writer.Write(buf)
+}
+
+// sends the header if it's not already sent, for empty files.
+func (w *responseWriter) flush() error {
+	if w.sent {
+		return nil
+	}
+
+	w.sent = true
+	return network.Send(w.Writer, &network.FileResponseMsg{
+		Status: network.StatusOK,
+		Length: w.length,
+	})
+}
+
+// sends the error status `status` to the user, returns `err`.
+func respondError(user io.Writer, status uint8, err error) error {
+	res := &network.FileResponseMsg{Status: status, Error: err.Error()}
+	if sendErr := network.Send(user, res); sendErr != nil {
+		return errors.Join(err, sendErr)
+	}
+	return err
+}
+
+// streams the file requested to the user, behind the response header.
+// Returns the number of bytes of content sent, and the status of the response.
+func sendFile(user io.Writer, req *network.FileRequestMsg) (int, uint8, error) {
+	fileName := hex.EncodeToString(req.Digest[:])
+	filePath := database.AccessCluster.Append(fileName).String()
+
+	// read + decrypt file
+	file, err := os.OpenFile(filePath, os.O_RDONLY, 0666)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, network.StatusNotFound, respondError(user, network.StatusNotFound, err)
+	} else if err != nil {
+		return 0, network.StatusInternal, respondError(user, network.StatusInternal, err)
+	}
+
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, network.StatusInternal, respondError(user, network.StatusInternal, err)
+	}
+
+	var (
+		pubKey []byte = req.PubKey[:]
+		secKey []byte = crypto.DataNodeSecretKey[:]
+	)
+
+	s, err := crypto.NewFileStream(secKey, pubKey)
+	if err != nil {
+		return 0, network.StatusInternal, respondError(user, network.StatusInternal, err)
+	}
+
+	// the header goes out with the first block decrypted, so a corrupted file
+	// or a wrong key is still reported to the user
+	w := &responseWriter{
+		Writer: user,
+		length: uint64(crypto.PlaintextSize(info.Size())),
+		sent:   false,
+	}
+
+	n, err := s.DecryptAndCopy(w, file)
+	if err != nil && !w.sent {
+		status := uint8(network.StatusInternal)
+		if errors.Is(err, crypto.ErrDecrypt) {
+			status = network.StatusDecryptFailed
+		}
+		return n, status, respondError(user, status, err)
+	}
+
+	// past the header, the user finds out from the content length
+	if err != nil {
+		return n, network.StatusInternal, err
+	}
+
+	return n, network.StatusOK, w.flush()
+}
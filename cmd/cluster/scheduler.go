@@ -0,0 +1,95 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/hn275/distributed-storage/internal/network"
+	"github.com/hn275/distributed-storage/internal/qos"
+)
+
+// serves the requests of a data node, `capacity` at a time. The waiting
+// requests are picked by priority class, FIFO within a class.
+type scheduler struct {
+	mtx    *sync.Mutex
+	cond   *sync.Cond
+	queue  *qos.Queue[*request]
+	closed bool
+
+	// requests being served, and max number of requests served or waiting
+	active int
+	limit  int
+}
+
+// at most `queueSize` requests wait for a worker, unbounded if
+// `qos.Unbounded`.
+func newScheduler(
+	capacity int, conf qos.Config, queueSize int, serve func(*request),
+) (*scheduler, error) {
+	queue, err := qos.NewQueue[*request](
+		network.PriorityClasses, conf, qos.Unbounded)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := qos.Unbounded
+	if queueSize != qos.Unbounded {
+		limit = capacity + queueSize
+	}
+
+	mtx := new(sync.Mutex)
+	s := &scheduler{mtx, sync.NewCond(mtx), queue, false, 0, limit}
+
+	for range capacity {
+		go s.work(serve)
+	}
+
+	return s, nil
+}
+
+// queues the request, never blocks. Returns false if the queue is full.
+func (s *scheduler) submit(req *request) bool {
+	s.mtx.Lock()
+	full := s.limit != qos.Unbounded && s.active+s.queue.Len() >= s.limit
+	if !full {
+		s.queue.Push(int(req.msg.Class), req)
+	}
+	s.mtx.Unlock()
+
+	if !full {
+		s.cond.Signal()
+	}
+	return !full
+}
+
+// the workers exit once the queued requests are served.
+func (s *scheduler) close() {
+	s.mtx.Lock()
+	s.closed = true
+	s.mtx.Unlock()
+
+	s.cond.Broadcast()
+}
+
+func (s *scheduler) work(serve func(*request)) {
+	for {
+		s.mtx.Lock()
+		for s.queue.Len() == 0 && !s.closed {
+			s.cond.Wait()
+		}
+		req, ok := s.queue.Pop()
+		if ok {
+			s.active += 1
+		}
+		s.mtx.Unlock()
+
+		if !ok {
+			return
+		}
+
+		serve(req)
+
+		s.mtx.Lock()
+		s.active -= 1
+		s.mtx.Unlock()
+	}
+}
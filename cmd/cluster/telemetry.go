@@ -15,12 +15,13 @@ const (
 	peerLB   = peerType("load-balance")
 	peerUser = peerType("user")
 
-	eventNodeOnline   = eventType("node-online")
-	eventNodeOffline  = eventType("node-offline")
-	eventPortForward  = eventType("port-forwarding")
-	eventHealthCheck  = eventType("healthcheck")
-	eventFileTransfer = eventType("file-transfer")
-	eventRequestRecv  = eventType("request-received")
+	eventNodeOnline      = eventType("node-online")
+	eventNodeOffline     = eventType("node-offline")
+	eventPortForward     = eventType("port-forwarding")
+	eventHealthCheck     = eventType("healthcheck")
+	eventFileTransfer    = eventType("file-transfer")
+	eventRequestRecv     = eventType("request-received")
+	eventRequestRejected = eventType("request-rejected")
 )
 
 var eventHeaders = []string{
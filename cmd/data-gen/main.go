@@ -17,12 +17,12 @@ import (
 )
 
 var fileSizeOpts = [...]uint64{
-	1 << 8,
-	1 << 16,
-	1 << 20,
-	1 << 24,
-	1 << 28,
-	1 << 30,
+	database.SizeXsmall,
+	database.SizeSmall,
+	database.SizeMedium,
+	database.SizeLarge,
+	database.SizeXlarge,
+	database.SizeXXlarge,
 }
 
 func main() {
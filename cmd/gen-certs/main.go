@@ -0,0 +1,24 @@
+// gen-certs generates the local CA and the certificates of the load balancer,
+// the data nodes, the users and the admin, for the `tls` transport.
+package main
+
+import (
+	"flag"
+	"log"
+	"log/slog"
+
+	"github.com/hn275/distributed-storage/internal/config"
+	"github.com/hn275/distributed-storage/internal/transport"
+)
+
+func main() {
+	var certDir string
+	flag.StringVar(&certDir, "dir", config.DefaultCertDir, "directory the certificates are written to")
+	flag.Parse()
+
+	if err := transport.GenerateCerts(certDir); err != nil {
+		log.Fatalf("failed to generate certificates: %v", err)
+	}
+
+	slog.Info("certificates generated.", "dir", certDir, "roles", transport.Roles)
+}
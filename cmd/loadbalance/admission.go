@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/hn275/distributed-storage/internal/config"
+	"github.com/hn275/distributed-storage/internal/network"
+	"github.com/hn275/distributed-storage/internal/telemetry"
+)
+
+var errLBBusy = errors.New("no node to dispatch the queued request to")
+
+// a request waiting for an in-flight slot.
+type pendingRequest struct {
+	msg      *network.UserNodeJoinMsg
+	user     net.Conn // relay and redirect modes only
+	enqueued time.Time
+}
+
+// true if a new request can be dispatched right away. Caller must hold
+// `lb.lock`.
+func (lb *loadBalancer) admit() bool {
+	return lb.maxInFlight == 0 || lb.inFlight < lb.maxInFlight
+}
+
+// dispatches the queued requests while there are free in-flight slots.
+// Caller must hold `lb.lock`.
+func (lb *loadBalancer) drainQueue() {
+	for lb.admit() {
+		req, ok := lb.queue.Pop()
+		if !ok {
+			return
+		}
+
+		ts := time.Now()
+		node, err := lb.dispatch(req.msg, req.user)
+
+		// in direct mode the user was let go of, and waits for a data node.
+		// The request is retried on the next free slot, or the next node.
+		if err != nil && req.user == nil {
+			logger.Error("failed to dispatch queued request, requeued.", "err", err)
+			lb.queue.PushFront(int(req.msg.Class), req)
+			return
+		}
+
+		if err != nil {
+			logger.Error("failed to dispatch queued request.", "err", err)
+			lb.turnDown(req)
+			continue
+		}
+
+		// the redirected user dials the node, in relay mode the relay owns the
+		// user's connection from now on
+		if lb.mode == config.ModeRedirect {
+			closeConn(req.user)
+		}
+
+		lb.tel.Collect(&telemetry.LBEvent{
+			Type:      telemetry.LBDequeued,
+			Peer:      telemetry.PeerUser,
+			PeerID:    int32(node.id),
+			Timestamp: ts,
+			Duration:  ts.Sub(req.enqueued).Nanoseconds(),
+			AvgRT:     node.avgRT,
+			ActiveReq: node.requestCtr,
+			Queue:     makeQueueString(lb),
+		})
+	}
+}
+
+// how long a user turned down in relay mode has to send its file request.
+const turnDownTimeout = 5 * time.Second
+
+// tells the user still connected that its queued request won't be served,
+// and closes its connection. Caller must hold `lb.lock`.
+func (lb *loadBalancer) turnDown(req *pendingRequest) {
+	lb.tel.Collect(&telemetry.LBEvent{
+		Type:      telemetry.LBRejected,
+		Peer:      telemetry.PeerUser,
+		PeerID:    -1,
+		Timestamp: time.Now(),
+		Duration:  time.Since(req.enqueued).Nanoseconds(),
+		Queue:     makeQueueString(lb),
+	})
+
+	if lb.mode == config.ModeRedirect {
+		if err := network.Send(req.user, &network.ServerBusyMsg{}); err != nil {
+			logger.Error("failed to turn down queued request.", "err", err)
+		}
+		closeConn(req.user)
+		return
+	}
+
+	// the relayed user reads the data node's response, it's answered as a
+	// busy data node would once its file request is in
+	go func() {
+		defer closeConn(req.user)
+
+		err := req.user.SetDeadline(time.Now().Add(turnDownTimeout))
+		if err == nil {
+			_, err = network.Receive(req.user)
+		}
+		if err == nil {
+			err = network.Send(req.user, &network.FileResponseMsg{
+				Status: network.StatusBusy,
+				Error:  errLBBusy.Error(),
+			})
+		}
+
+		if err != nil {
+			logger.Error("failed to turn down queued request.", "err", err)
+		}
+	}()
+}
@@ -1,27 +1,45 @@
 package main
 
 import (
-	"bytes"
-	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
+	"os"
 	"time"
 
 	"github.com/dustin/go-humanize"
 	"github.com/hn275/distributed-storage/internal/algo"
+	"github.com/hn275/distributed-storage/internal/config"
+	"github.com/hn275/distributed-storage/internal/health"
 	"github.com/hn275/distributed-storage/internal/network"
+	"github.com/hn275/distributed-storage/internal/telemetry"
 )
 
 type dataNode struct {
 	net.Conn
-	wchan chan []byte
-	id    uint16
+	wchan    chan []byte
+	id       uint16
+	weight   uint16
+	capacity uint16
 
+	addr       *net.TCPAddr // users are redirected to, redirect mode only
 	log        *slog.Logger
 	avgRT      float64
 	requestCtr uint64
 	index      int
+
+	// failure detection, nil if the node sends no heartbeat. The node is
+	// only scheduled while alive.
+	detector *health.Detector
+	state    health.State
+	nextSeq  uint64        // of the next heartbeat expected
+	done     chan struct{} // closed once the node left
+
+	// users redirected to the node and not reported on yet, by file digest,
+	// redirect mode only
+	redirects map[[32]byte][]*redirectLease
 }
 
 // for debugging
@@ -29,55 +47,69 @@ func (d *dataNode) String() string {
 	return fmt.Sprintf("(%d,%d)", d.id, d.requestCtr)
 }
 
-// SetIndex implements algo.QueueNode.
-func (d *dataNode) SetIndex(i int) {
-	d.index = i
+// Weight implements algo.Weighted.
+func (d *dataNode) Weight() uint16 {
+	return d.weight
 }
 
-const alpha = 0.4
+// Capacity implements algo.Capacitated.
+func (d *dataNode) Capacity() uint16 {
+	return d.capacity
+}
 
-func (d *dataNode) Less(other algo.QueueNode) bool {
-	o := other.(*dataNode)
+// ID implements algo.QueueNode.
+func (d *dataNode) ID() uint16 {
+	return d.id
+}
 
-	switch globConf.LoadBalancer.Algorithm {
-	case algo.AlgoSimpleRoundRobin:
-		return false // nop
+// ActiveRequests implements algo.QueueNode.
+func (d *dataNode) ActiveRequests() uint64 {
+	return d.requestCtr
+}
 
-	case algo.AlgoLeastResponseTime:
-		if d.requestCtr == o.requestCtr {
-			return d.avgRT < o.avgRT
-		} else {
-			return d.requestCtr < o.requestCtr
-		}
+// AvgResponseTime implements algo.QueueNode.
+func (d *dataNode) AvgResponseTime() float64 {
+	return d.avgRT
+}
 
-	case algo.AlgoLeastConnections:
-		return d.requestCtr < o.requestCtr
+// SetIndex implements algo.QueueNode.
+func (d *dataNode) SetIndex(i int) {
+	d.index = i
+}
 
-	default:
-		panic(fmt.Sprintf("not implemented [%s].", globConf.LoadBalancer.Algorithm))
-	}
+// Index implements algo.QueueNode.
+func (d *dataNode) Index() int {
+	return d.index
 }
 
-func makeDataNode(conn net.Conn, nodeID uint16) *dataNode {
+func makeDataNode(conn net.Conn, nodeID, weight, capacity uint16) *dataNode {
 	wchan := make(chan []byte, 100)
 
-	logger := slog.Default().With("node-id", nodeID)
+	logger := slog.Default().With(
+		"node-id", nodeID, "weight", weight, "capacity", capacity)
 
 	dataNode := &dataNode{
 		Conn:       conn,
 		wchan:      wchan,
 		id:         nodeID,
+		weight:     weight,
+		capacity:   capacity,
 		log:        logger,
 		avgRT:      0.0,
 		requestCtr: 0,
 		index:      0,
+
+		detector: nil,
+		state:    health.Alive,
+		nextSeq:  0,
+		done:     make(chan struct{}),
+
+		redirects: make(map[[32]byte][]*redirectLease),
 	}
-	go dataNode.listen()
 
-	// write routine
+	// write routine, exits once the node left
 	go func(wchan <-chan []byte) {
-		for {
-			buf := <-wchan
+		for buf := range wchan {
 			if n, err := conn.Write(buf); err != nil {
 				logger.Error("failed socket write.",
 					"err", err,
@@ -97,56 +129,227 @@ func (dn *dataNode) write(buf []byte) {
 }
 
 func (d *dataNode) listen() {
+	defer d.leave()
+
 	for {
-		buf := make([]byte, 16)
-		_, err := d.Read(buf)
+		msg, err := network.Receive(d)
 		if err != nil {
-			d.log.Error("failed to read socket",
-				"err", err)
-			continue
+			if errors.Is(err, io.EOF) {
+				d.log.Info("data node disconnected.")
+			} else if errors.Is(err, os.ErrDeadlineExceeded) {
+				d.log.Warn("data node declared dead.")
+			} else {
+				d.log.Error("failed to read socket",
+					"err", err)
+			}
+			return
 		}
 
-		switch buf[0] {
+		switch msg := msg.(type) {
+
+		case *network.HealthCheckMsg:
+			go d.handleHealthCheck(msg)
 
-		case network.HealthCheck:
-			go d.handleHealthCheck(buf)
+		case *network.HeartbeatMsg:
+			d.handleHeartbeat(msg)
 
 		default:
-			d.log.Error("unsupported message type", "type", buf[0])
+			d.log.Error("unsupported message type", "type", msg.Type())
 		}
 	}
 
 }
 
-func (d *dataNode) handleHealthCheck(buf []byte) {
+// removes the node from scheduling, once its socket is dead.
+func (d *dataNode) leave() {
 	ts := time.Now()
 
 	lbSrv.lock.Lock()
 	defer lbSrv.lock.Unlock()
 
+	// a suspected node is already out of scheduling
+	if d.state == health.Alive {
+		lbSrv.engine.NodeLeave(d)
+	}
+	d.state = health.Dead
+	close(d.wchan)
+	close(d.done)
+	closeConn(d.Conn)
+	d.dropRedirects()
+
+	// the requests in flight on the node are lost
+	lbSrv.inFlight -= min(d.requestCtr, lbSrv.inFlight)
+	lbSrv.drainQueue()
+
+	d.log.Info("data node left.", "active_requests", d.requestCtr)
+	lbSrv.tel.Collect(&telemetry.LBEvent{
+		Type:      telemetry.LBNodeLeave,
+		Peer:      telemetry.PeerDataNode,
+		PeerID:    int32(d.id),
+		Timestamp: ts,
+		Duration:  time.Since(ts).Nanoseconds(),
+		AvgRT:     d.avgRT,
+		ActiveReq: d.requestCtr,
+		Queue:     makeQueueString(lbSrv),
+	})
+}
+
+func (d *dataNode) handleHealthCheck(msg *network.HealthCheckMsg) {
+	ts := time.Now()
+
+	lbSrv.lock.Lock()
+	defer lbSrv.lock.Unlock()
+
+	// the node left, its requests in flight are already released
+	if d.state == health.Dead {
+		return
+	}
+
+	// the redirected user was given up on, its slot is already released
+	if lbSrv.mode == config.ModeRedirect && !d.claimRedirect(msg.Digest) {
+		d.log.Warn("late health check, slot already released.")
+		return
+	}
+
+	// a slot was freed, runs before the lock is released
+	defer lbSrv.drainQueue()
+
 	// data node sends a health check message when it's done serving the client.
 	// so the active requests is reduced by 1.
-	d.requestCtr -= 1
+	d.release(msg.Digest)
+
+	// failed requests say nothing of the node's speed
+	d.avgRT = msg.AvgRT
+	if observer, ok := lbSrv.engine.(algo.Observer); ok && msg.Status == network.StatusOK {
+		observer.Observe(d, msg.Digest[:], msg.Latency)
+	}
 
-	bufReader := bytes.NewReader(buf[1:])
-	err := binary.Read(bufReader, network.BinaryEndianess, &d.avgRT)
-	if err != nil {
-		d.log.Error("HealthCheck failed.", "err", err)
+	if d.state == health.Alive {
+		if err := lbSrv.engine.Fix(d.index); err != nil {
+			d.log.Error("failed priority queue fixes.", "err", err)
+			return
+		}
+	}
+
+	lbSrv.tel.Collect(&telemetry.LBEvent{
+		Type:      telemetry.LBHealthCheck,
+		Peer:      telemetry.PeerDataNode,
+		PeerID:    int32(d.id),
+		Timestamp: ts,
+		Duration:  time.Since(ts).Nanoseconds(),
+		AvgRT:     d.avgRT,
+		ActiveReq: d.requestCtr,
+		Queue:     makeQueueString(lbSrv),
+	})
+}
+
+// releases the slot of a request for `digest` done on the node, whether it
+// was served or not. Caller must hold `lbSrv.lock`.
+func (d *dataNode) release(digest [32]byte) {
+	d.requestCtr -= min(1, d.requestCtr)
+	lbSrv.inFlight -= min(1, lbSrv.inFlight)
+
+	if completer, ok := lbSrv.engine.(algo.Completer); ok {
+		completer.Complete(d, digest[:])
+	}
+}
+
+// a heartbeat brings a suspected node back into scheduling.
+func (d *dataNode) handleHeartbeat(msg *network.HeartbeatMsg) {
+	ts := time.Now()
+
+	lbSrv.lock.Lock()
+	defer lbSrv.lock.Unlock()
+
+	if d.detector == nil {
+		d.log.Warn("unexpected heartbeat, failure detection is disabled.")
 		return
 	}
 
-	if err := lbSrv.engine.Fix(d.index); err != nil {
-		d.log.Error("failed priority queue fixes.", "err", err)
+	if msg.Seq > d.nextSeq {
+		d.log.Warn("heartbeats lost.", "count", msg.Seq-d.nextSeq)
+	}
+	d.nextSeq = msg.Seq + 1
+	d.detector.Heartbeat(ts)
+
+	// a dead node is already on its way out
+	if d.state != health.Suspect {
 		return
 	}
 
-	lbSrv.tel.Collect(&event{
-		eType:     eventHealthCheck,
-		peer:      peerDataNode,
-		peerID:    int32(d.id),
-		timestamp: ts,
-		duration:  time.Since(ts).Nanoseconds(),
-		avgRT:     d.avgRT,
-		activeReq: d.requestCtr,
+	d.state = health.Alive
+	lbSrv.engine.NodeJoin(d)
+
+	d.log.Info("data node recovered.")
+	d.collectHealth(telemetry.LBNodeRecovered, ts)
+
+	// the node can take the queued requests
+	lbSrv.drainQueue()
+}
+
+// checks the node's heartbeats every half interval, until the node left or
+// it's declared dead.
+func (d *dataNode) monitor(interval time.Duration) {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.done:
+			return
+		case ts := <-ticker.C:
+			lbSrv.lock.Lock()
+			state := d.checkHealth(ts)
+			lbSrv.lock.Unlock()
+
+			if state == health.Dead {
+				return
+			}
+		}
+	}
+}
+
+// takes a suspected node out of scheduling, and disconnects a dead node. Only
+// a heartbeat clears the suspicion. Caller must hold `lbSrv.lock`.
+func (d *dataNode) checkHealth(ts time.Time) health.State {
+	state := d.detector.State(ts)
+	if state <= d.state {
+		return d.state
+	}
+
+	if d.state == health.Alive {
+		lbSrv.engine.NodeLeave(d)
+	}
+	d.state = state
+
+	switch state {
+	case health.Suspect:
+		d.log.Warn("data node suspected.", "suspicion", d.detector.Suspicion(ts))
+		d.collectHealth(telemetry.LBNodeSuspect, ts)
+
+	case health.Dead:
+		d.log.Warn("data node dead.", "suspicion", d.detector.Suspicion(ts))
+		d.collectHealth(telemetry.LBNodeDead, ts)
+
+		// the node leaves once its read is cut short
+		if err := d.SetReadDeadline(ts); err != nil {
+			d.log.Error("failed to disconnect data node.", "err", err)
+		}
+	}
+
+	return state
+}
+
+// Caller must hold `lbSrv.lock`.
+func (d *dataNode) collectHealth(eventType string, ts time.Time) {
+	lbSrv.tel.Collect(&telemetry.LBEvent{
+		Type:      eventType,
+		Peer:      telemetry.PeerDataNode,
+		PeerID:    int32(d.id),
+		Timestamp: ts,
+		Duration:  time.Since(ts).Nanoseconds(),
+		AvgRT:     d.avgRT,
+		ActiveReq: d.requestCtr,
+		Queue:     makeQueueString(lbSrv),
 	})
 }
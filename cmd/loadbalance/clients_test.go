@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/hex"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hn275/distributed-storage/internal/algo"
+	"github.com/hn275/distributed-storage/internal/config"
+	"github.com/hn275/distributed-storage/internal/network"
+	"github.com/hn275/distributed-storage/internal/qos"
+	"github.com/hn275/distributed-storage/internal/telemetry"
+	"github.com/hn275/distributed-storage/internal/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+// sets up `lbSrv` in direct mode, without a listener.
+func newTestLB(t *testing.T, engine algo.LBAlgo) {
+	tel, err := telemetry.New(filepath.Join(t.TempDir(), "lb.csv"), telemetry.LBHeaders)
+	assert.Nil(t, err)
+	t.Cleanup(tel.Done)
+
+	queue, err := qos.NewQueue[*pendingRequest](
+		network.PriorityClasses, qos.Config{}, qos.Unbounded)
+	assert.Nil(t, err)
+
+	engine.Initialize()
+	lbSrv = &loadBalancer{
+		engine: engine,
+		lock:   new(sync.Mutex),
+		tel:    tel,
+		mode:   config.ModeDirect,
+		queue:  queue,
+	}
+	globConf = &config.Config{}
+}
+
+// a node joined to `lbSrv`, the other end of its socket is discarded.
+func newTestNode(t *testing.T, nodeID uint16) *dataNode {
+	conn, peer := net.Pipe()
+	t.Cleanup(func() { peer.Close() })
+
+	node := makeDataNode(conn, nodeID, 1, 1)
+	lbSrv.engine.NodeJoin(node)
+	return node
+}
+
+// dispatches a request for `digest` to the node the algorithm picks.
+func testDispatch(t *testing.T, digest [32]byte) *dataNode {
+	lbSrv.lock.Lock()
+	defer lbSrv.lock.Unlock()
+
+	node, err := lbSrv.engine.(algo.KeyedLBAlgo).GetNodeByKey(digest[:])
+	assert.Nil(t, err)
+
+	d := node.(*dataNode)
+	d.requestCtr += 1
+	lbSrv.inFlight += 1
+	lbSrv.engine.PutNode(d)
+	return d
+}
+
+func TestHealthCheckFailedRequest(t *testing.T) {
+	digest := [32]byte{1}
+	newTestLB(t, &algo.LeastOutstandingBytes{
+		Sizes: map[string]uint64{hex.EncodeToString(digest[:]): 1 << 20},
+	})
+
+	node := newTestNode(t, 0)
+	assert.Same(t, node, testDispatch(t, digest))
+	assert.Equal(t, float64(1<<20), lbSrv.engine.Snapshot()[0].Score)
+
+	// the file isn't served, its bytes are no longer outstanding either way
+	node.handleHealthCheck(&network.HealthCheckMsg{
+		Digest: digest,
+		Status: network.StatusNotFound,
+	})
+
+	assert.Equal(t, float64(0), lbSrv.engine.Snapshot()[0].Score)
+	assert.Equal(t, uint64(0), node.requestCtr)
+	assert.Equal(t, uint64(0), lbSrv.inFlight)
+}
+
+func TestHealthCheckAfterLeave(t *testing.T) {
+	digest := [32]byte{1}
+	newTestLB(t, &algo.LeastOutstandingBytes{})
+
+	node := newTestNode(t, 0)
+	other := newTestNode(t, 1)
+	testDispatch(t, digest)
+	testDispatch(t, digest)
+	assert.Equal(t, uint64(2), lbSrv.inFlight)
+
+	// the node's socket hits EOF with its health check still in flight
+	node.leave()
+	assert.Equal(t, uint64(1), lbSrv.inFlight)
+
+	node.handleHealthCheck(&network.HealthCheckMsg{Digest: digest})
+	assert.Equal(t, uint64(1), lbSrv.inFlight)
+	assert.Equal(t, uint64(1), node.requestCtr)
+
+	other.handleHealthCheck(&network.HealthCheckMsg{Digest: digest})
+	assert.Equal(t, uint64(0), lbSrv.inFlight)
+	assert.True(t, lbSrv.admit())
+}
+
+func TestRedirectExpired(t *testing.T) {
+	digest, other := [32]byte{1}, [32]byte{2}
+	newTestLB(t, &algo.LeastOutstandingBytes{})
+	lbSrv.mode = config.ModeRedirect
+
+	node := newTestNode(t, 0)
+	for _, key := range [][32]byte{digest, digest, other} {
+		testDispatch(t, key)
+		node.expectRedirect(key)
+	}
+	assert.Equal(t, uint64(3), lbSrv.inFlight)
+
+	// the first user never dialed the node
+	node.expireRedirect(digest, node.redirects[digest][0])
+	assert.Equal(t, uint64(2), lbSrv.inFlight)
+	assert.Equal(t, uint64(2), node.requestCtr)
+
+	node.handleHealthCheck(&network.HealthCheckMsg{Digest: digest})
+	assert.Equal(t, uint64(1), lbSrv.inFlight)
+
+	// no user left to report on, the slot of `other` is still taken
+	node.handleHealthCheck(&network.HealthCheckMsg{Digest: digest})
+	assert.Equal(t, uint64(1), lbSrv.inFlight)
+	assert.Equal(t, uint64(1), node.requestCtr)
+
+	node.handleHealthCheck(&network.HealthCheckMsg{Digest: other})
+	assert.Equal(t, uint64(0), lbSrv.inFlight)
+	assert.Empty(t, node.redirects)
+}
+
+func TestDispatchFailed(t *testing.T) {
+	digest := [32]byte{1}
+	newTestLB(t, &algo.LeastOutstandingBytes{
+		Sizes: map[string]uint64{hex.EncodeToString(digest[:]): 1 << 20},
+	})
+	lbSrv.mode = config.ModeRedirect
+
+	node := newTestNode(t, 0)
+	node.addr = &net.TCPAddr{IP: net.IPv6loopback, Port: 9000}
+
+	// the user hung up before it could be redirected
+	user, peer := net.Pipe()
+	peer.Close()
+	defer user.Close()
+
+	lbSrv.lock.Lock()
+	_, err := lbSrv.dispatch(&network.UserNodeJoinMsg{Digest: digest}, user)
+	lbSrv.lock.Unlock()
+	assert.NotNil(t, err)
+
+	assert.Equal(t, float64(0), lbSrv.engine.Snapshot()[0].Score)
+	assert.Equal(t, uint64(0), node.requestCtr)
+	assert.Equal(t, uint64(0), lbSrv.inFlight)
+}
+
+func TestDrainQueueRequeue(t *testing.T) {
+	digest := [32]byte{1}
+	newTestLB(t, &algo.LeastOutstandingBytes{})
+	lbSrv.maxInFlight = 1
+
+	// accepted in direct mode, the user waits on its listener
+	lbSrv.queue.Push(0, &pendingRequest{
+		msg: &network.UserNodeJoinMsg{
+			Addr:   &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8000},
+			Digest: digest,
+		},
+		enqueued: time.Now(),
+	})
+
+	// no node to dispatch to, the request is kept
+	lbSrv.lock.Lock()
+	lbSrv.drainQueue()
+	lbSrv.lock.Unlock()
+	assert.Equal(t, 1, lbSrv.queue.Len())
+
+	node := newTestNode(t, 0)
+	lbSrv.lock.Lock()
+	lbSrv.drainQueue()
+	lbSrv.lock.Unlock()
+	assert.Equal(t, 0, lbSrv.queue.Len())
+	assert.Equal(t, uint64(1), node.requestCtr)
+}
+
+func TestDrainQueueTurnDown(t *testing.T) {
+	newTestLB(t, &algo.LeastOutstandingBytes{})
+	lbSrv.mode = config.ModeRedirect
+	lbSrv.maxInFlight = 1
+
+	user, peer := net.Pipe()
+	defer peer.Close()
+	lbSrv.queue.Push(0, &pendingRequest{
+		msg:      &network.UserNodeJoinMsg{},
+		user:     user,
+		enqueued: time.Now(),
+	})
+
+	status := make(chan network.Message, 1)
+	go func() {
+		msg, err := network.Receive(peer)
+		assert.Nil(t, err)
+		status <- msg
+	}()
+
+	// no node to redirect to, the user still connected is turned down
+	lbSrv.lock.Lock()
+	lbSrv.drainQueue()
+	lbSrv.lock.Unlock()
+
+	assert.IsType(t, &network.ServerBusyMsg{}, <-status)
+	assert.Equal(t, 0, lbSrv.queue.Len())
+}
+
+func TestListenStalledPeer(t *testing.T) {
+	newTestLB(t, &algo.LeastOutstandingBytes{})
+	ln, err := net.Listen(network.ProtoTcp, "127.0.0.1:0")
+	assert.Nil(t, err)
+	lbSrv.Listener, lbSrv.tr = ln, transport.Plain()
+
+	done := make(chan struct{})
+	go func() {
+		lbSrv.listen()
+		close(done)
+	}()
+
+	// connects, never says a word
+	stalled, err := net.Dial(network.ProtoTcp, ln.Addr().String())
+	assert.Nil(t, err)
+	defer stalled.Close()
+
+	conn, err := net.Dial(network.ProtoTcp, ln.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+	assert.Nil(t, network.Send(conn, &network.ShutdownSigMsg{}))
+
+	select {
+	case <-done:
+	case <-time.After(pingTimeout / 2):
+		ln.Close()
+		t.Fatal("the stalled peer blocked the accept loop.")
+	}
+}
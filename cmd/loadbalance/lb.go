@@ -9,8 +9,12 @@ import (
 	"time"
 
 	"github.com/hn275/distributed-storage/internal/algo"
+	"github.com/hn275/distributed-storage/internal/config"
+	"github.com/hn275/distributed-storage/internal/health"
 	"github.com/hn275/distributed-storage/internal/network"
+	"github.com/hn275/distributed-storage/internal/qos"
 	"github.com/hn275/distributed-storage/internal/telemetry"
+	"github.com/hn275/distributed-storage/internal/transport"
 )
 
 type loadBalancer struct {
@@ -18,31 +22,59 @@ type loadBalancer struct {
 	engine algo.LBAlgo
 	lock   *sync.Mutex
 	tel    *telemetry.Telemetry
+	mode   string
+	tr     *transport.Transport
+
+	// admission control, disabled if `maxInFlight` is 0
+	inFlight    uint64
+	maxInFlight uint64
+	queue       *qos.Queue[*pendingRequest]
 }
 
-func newLB(port int, algorithm algo.LBAlgo, tel *telemetry.Telemetry) (*loadBalancer, error) {
+func newLB(
+	addr string, algorithm algo.LBAlgo, tel *telemetry.Telemetry, mode string,
+	tr *transport.Transport, admission config.AdmissionYaml,
+) (*loadBalancer, error) {
+	// requests waiting for a slot, dispatched by priority class
+	queue, err := qos.NewQueue[*pendingRequest](
+		network.PriorityClasses, admission.Scheduler, admission.GetQueueSize())
+	if err != nil {
+		return nil, err
+	}
+
 	// open listening socket
-	portStr := fmt.Sprintf(":%d", port)
-	soc, err := net.Listen(network.ProtoTcp4, portStr)
+	soc, err := net.Listen(network.ProtoTcp, addr)
 
 	if err != nil {
 		return nil, err
 	}
 
 	lbSrv := &loadBalancer{
-		Listener: soc,
+		Listener: tr.Listen(soc),
 		engine:   algorithm,
 		lock:     new(sync.Mutex),
 		tel:      tel,
+		mode:     mode,
+		tr:       tr,
+
+		inFlight:    0,
+		maxInFlight: admission.MaxInFlight,
+		queue:       queue,
 	}
 	return lbSrv, nil
 }
 
-// server handlers
+// role of the peers allowed to send each ping, checked on a secure transport.
+var pingRoles = map[uint8]string{
+	network.DataNodeJoin: transport.RoleNode,
+	network.UserNodeJoin: transport.RoleUser,
+	network.AlgoSwitch:   transport.RoleAdmin,
+	network.ShutdownSig:  transport.RoleUser,
+}
 
-type lbHandler func(net.Conn, []byte) error
+// server handlers
 
-func handle(fn lbHandler, conn net.Conn, msg []byte) {
+func handle[T network.Message](fn func(net.Conn, T) error, conn net.Conn, msg T) {
 	if err := fn(conn, msg); err != nil {
 		logger.Error(
 			"handler for peer returned an error.",
@@ -52,107 +84,347 @@ func handle(fn lbHandler, conn net.Conn, msg []byte) {
 	}
 }
 
-// listener
+// how long a peer has to send its first message, the handshake included.
+const pingTimeout = 10 * time.Second
+
+// listener, returns once the listener is closed.
 func (lbSrv *loadBalancer) listen() {
 	for {
 		conn, err := lbSrv.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
-			logger.Error("failed to accept new conn.",
-				"peer", conn.RemoteAddr,
+			logger.Error("failed to accept new conn.", "err", err)
+			continue
+		}
+
+		go lbSrv.serve(conn)
+	}
+}
+
+// reads the first message of the peer, and hands it over to its handler. A
+// peer stalling the handshake only holds up its own goroutine.
+func (lbSrv *loadBalancer) serve(conn net.Conn) {
+	if err := conn.SetDeadline(time.Now().Add(pingTimeout)); err != nil {
+		logger.Error("failed to set deadline.", "remote_addr", conn.RemoteAddr(), "err", err)
+		closeConn(conn)
+		return
+	}
+
+	msg, err := network.Receive(conn)
+	if err != nil {
+		// silent return if peer disconnected
+		if !errors.Is(err, io.EOF) {
+			logger.Error("failed to read from socket.",
+				"remote_addr", conn.RemoteAddr(),
 				"err", err,
 			)
-			continue
 		}
+		closeConn(conn)
+		return
+	}
 
-		buf := make([]byte, 16)
-		n, err := conn.Read(buf)
-		if err != nil {
-			// silent continue if peer disconnected
-			if !errors.Is(err, io.EOF) {
-				logger.Error("failed to read from socket.",
-					"remote_addr", conn.RemoteAddr(),
-					"err", err,
-				)
-			}
-			continue
+	// the handshake is done by the first read
+	if role, ok := pingRoles[msg.Type()]; ok {
+		if err := lbSrv.tr.Authorize(conn, role); err != nil {
+			logger.Error("peer not authorized.",
+				"remote_addr", conn.RemoteAddr(),
+				"msgtype", msg.Type(),
+				"err", err,
+			)
+			closeConn(conn)
+			return
 		}
+	}
 
-		switch buf[0] {
-		case network.DataNodeJoin:
-			go handle(lbSrv.nodeJoinHandler, conn, buf[:n])
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		logger.Error("failed to clear deadline.", "remote_addr", conn.RemoteAddr(), "err", err)
+		closeConn(conn)
+		return
+	}
 
-		case network.UserNodeJoin:
-			logger.Info("new user.", "remote_addr", conn.RemoteAddr())
-			go handle(lbSrv.userJoinHandler, conn, buf)
+	switch msg := msg.(type) {
+	case *network.DataNodeJoinMsg:
+		handle(lbSrv.nodeJoinHandler, conn, msg)
 
-		case network.ShutdownSig:
-			return
+	case *network.UserNodeJoinMsg:
+		logger.Info("new user.", "remote_addr", conn.RemoteAddr())
+		handle(lbSrv.userJoinHandler, conn, msg)
 
-		default:
-			logger.Error("unsupported ping message type.", "msgtype", buf[0])
-			closeConn(conn)
+	case *network.AlgoSwitchMsg:
+		handle(lbSrv.algoSwitchHandler, conn, msg)
+
+	case *network.ShutdownSigMsg:
+		closeConn(conn)
+		// stops the accept loop
+		if err := lbSrv.Close(); err != nil {
+			logger.Error("failed to close listener.", "err", err)
 		}
+
+	default:
+		logger.Error("unsupported ping message type.", "msgtype", msg.Type())
+		closeConn(conn)
 	}
 }
 
-func (lb *loadBalancer) userJoinHandler(user net.Conn, buf []byte) error {
+func (lb *loadBalancer) userJoinHandler(user net.Conn, msg *network.UserNodeJoinMsg) error {
 	ts := time.Now()
 
+	// the connection is handed over to the queue, or to the relay
+	handedOver := false
+	defer func() {
+		if !handedOver {
+			closeConn(user)
+		}
+	}()
+
 	// request for a data node
 	lb.lock.Lock()
 	defer lb.lock.Unlock()
 
-	node, err := lb.engine.GetNode()
+	// all in-flight slots taken, the request waits in queue, or is turned
+	// down if the queue is full
+	if !lb.admit() {
+		pending := &pendingRequest{msg: msg, user: nil, enqueued: ts}
+		if lb.mode != config.ModeDirect {
+			pending.user = user
+		}
+
+		if !lb.queue.Push(int(msg.Class), pending) {
+			lb.tel.Collect(&telemetry.LBEvent{
+				Type:      telemetry.LBRejected,
+				Peer:      telemetry.PeerUser,
+				PeerID:    -1,
+				Timestamp: ts,
+				Duration:  time.Since(ts).Nanoseconds(),
+				Queue:     makeQueueString(lb),
+			})
+
+			return network.Send(user, &network.ServerBusyMsg{})
+		}
+
+		handedOver = pending.user != nil
+		lb.tel.Collect(&telemetry.LBEvent{
+			Type:      telemetry.LBQueued,
+			Peer:      telemetry.PeerUser,
+			PeerID:    -1,
+			Timestamp: ts,
+			Duration:  time.Since(ts).Nanoseconds(),
+			ActiveReq: uint64(lb.queue.Len()),
+			Queue:     makeQueueString(lb),
+		})
+
+		return network.Send(user, &network.RequestAcceptedMsg{})
+	}
+
+	node, err := lb.dispatch(msg, user)
 	if err != nil {
 		return err
 	}
 
+	handedOver = lb.mode == config.ModeRelay
+
+	lb.tel.Collect(&telemetry.LBEvent{
+		Type:      telemetry.LBUserJoin,
+		Peer:      telemetry.PeerUser,
+		PeerID:    int32(node.id),
+		Timestamp: ts,
+		Duration:  time.Since(ts).Nanoseconds(),
+		AvgRT:     node.avgRT,
+		ActiveReq: node.requestCtr,
+		Queue:     makeQueueString(lb),
+	})
+
+	// the user is already redirected
+	if lb.mode == config.ModeRedirect {
+		return nil
+	}
+	return network.Send(user, &network.RequestAcceptedMsg{})
+}
+
+// picks a data node and forwards the user's ping to it. In relay mode, the
+// response is relayed on `user`, closed once done. In redirect mode, the user
+// is sent to the node instead. Caller must hold `lb.lock`.
+func (lb *loadBalancer) dispatch(
+	msg *network.UserNodeJoinMsg, user net.Conn,
+) (*dataNode, error) {
+	var (
+		node algo.QueueNode
+		err  error
+	)
+
+	// route on the client, or the requested file, if the algorithm supports it
+	if sticky, ok := lb.engine.(algo.ClientLBAlgo); ok {
+		node, err = sticky.GetNodeByClient(msg.ClientID, msg.Digest[:])
+	} else if keyed, ok := lb.engine.(algo.KeyedLBAlgo); ok {
+		node, err = keyed.GetNodeByKey(msg.Digest[:])
+	} else {
+		node, err = lb.engine.GetNode()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
 	nodeQ := node.(*dataNode)
-	nodeQ.requestCtr += 1
+
+	// the user forwards its ping itself
+	if lb.mode == config.ModeRedirect {
+		err := network.Send(user, &network.RedirectMsg{Addr: nodeQ.addr})
+		if err != nil {
+			lb.cancel(nodeQ, msg.Digest)
+			return nil, err
+		}
+
+		nodeQ.requestCtr += 1
+		lb.inFlight += 1
+		nodeQ.expectRedirect(msg.Digest)
+		lb.engine.PutNode(nodeQ)
+		return nodeQ, nil
+	}
+
+	// the data node dials the LB instead of the user
+	var relayLn *net.TCPListener
+	if lb.mode == config.ModeRelay {
+		if relayLn, err = newRelayListener(nodeQ); err != nil {
+			lb.cancel(nodeQ, msg.Digest)
+			return nil, err
+		}
+
+		fwd := *msg
+		fwd.Addr = relayLn.Addr().(*net.TCPAddr)
+		msg = &fwd
+	}
 
 	// port fowarding
-	nodeQ.write(buf[:])
+	frame, err := network.Encode(msg)
+	if err != nil {
+		if relayLn != nil {
+			relayLn.Close()
+		}
+		lb.cancel(nodeQ, msg.Digest)
+		return nil, err
+	}
+
+	nodeQ.requestCtr += 1
+	lb.inFlight += 1
+
+	nodeQ.write(frame)
 
 	lb.engine.PutNode(nodeQ)
 
-	lb.tel.Collect(&event{
-		eType:     eventUserJoin,
-		peer:      peerUser,
-		peerID:    int32(nodeQ.id),
-		timestamp: ts,
-		duration:  time.Since(ts).Nanoseconds(),
-		avgRT:     nodeQ.avgRT,
-		activeReq: nodeQ.requestCtr,
-		queue:     makeQueueString(lb),
-	})
+	if relayLn != nil {
+		go lb.relay(relayLn, user, nodeQ.id)
+	}
+
+	return nodeQ, nil
+}
 
-	return err
+// puts back a node picked for a request that was never sent, the work the
+// algorithm handed to it is taken back. Caller must hold `lb.lock`.
+func (lb *loadBalancer) cancel(node *dataNode, digest [32]byte) {
+	if completer, ok := lb.engine.(algo.Completer); ok {
+		completer.Complete(node, digest[:])
+	}
+	lb.engine.PutNode(node)
 }
 
-func (lb *loadBalancer) nodeJoinHandler(node net.Conn, msg []byte) error {
+func (lb *loadBalancer) nodeJoinHandler(node net.Conn, msg *network.DataNodeJoinMsg) error {
 	ts := time.Now()
-	if len(msg) != 3 {
-		panic("protocol violation")
+	nodeId, weight, capacity := msg.NodeID, msg.Weight, msg.Capacity
+
+	// the users can't be redirected to a node that doesn't listen
+	if lb.mode == config.ModeRedirect && msg.Addr == nil {
+		closeConn(node)
+		return fmt.Errorf("data node %d has no address to redirect to", nodeId)
+	}
+
+	// weights set in the config file takes precedence
+	if w, ok := globConf.LoadBalancer.Weights[nodeId]; ok {
+		weight = w
+	}
+
+	// the nodes that don't send heartbeats are never suspected
+	var detector *health.Detector
+	detection := globConf.LoadBalancer.FailureDetector
+	if detection.Enabled() && msg.Heartbeat > 0 {
+		var err error
+		if detector, err = health.New(detection, msg.Heartbeat, ts); err != nil {
+			closeConn(node)
+			return err
+		}
 	}
 
-	nodeId := network.BinaryEndianess.Uint16(msg[1:])
+	dataNode := makeDataNode(node, nodeId, weight, capacity)
+	dataNode.addr = msg.Addr
+	dataNode.detector = detector
+
+	if detection.Enabled() && detector == nil {
+		dataNode.log.Warn("no heartbeat from data node, failure detection disabled.")
+	}
 
-	dataNode := makeDataNode(node, nodeId)
 	lb.lock.Lock()
 	lb.engine.NodeJoin(dataNode)
+	queue := makeQueueString(lb)
+	// the node can take the queued requests
+	lb.drainQueue()
 	lb.lock.Unlock()
 
+	// only listen once the node is schedulable, so it can leave
+	go dataNode.listen()
+	if dataNode.detector != nil {
+		go dataNode.monitor(msg.Heartbeat)
+	}
+
 	dataNode.log.Info("new data node.", "remote_addr", node.RemoteAddr())
-	lb.tel.Collect(&event{
-		eType:     eventNodeJoin,
-		peer:      peerDataNode,
-		peerID:    int32(nodeId),
-		timestamp: ts,
-		duration:  time.Since(ts).Nanoseconds(),
-		avgRT:     dataNode.avgRT,
-		activeReq: dataNode.requestCtr,
-		queue:     "",
+	lb.tel.Collect(&telemetry.LBEvent{
+		Type:      telemetry.LBNodeJoin,
+		Peer:      telemetry.PeerDataNode,
+		PeerID:    int32(nodeId),
+		Timestamp: ts,
+		Duration:  time.Since(ts).Nanoseconds(),
+		AvgRT:     dataNode.avgRT,
+		ActiveReq: dataNode.requestCtr,
+		Queue:     queue,
 	})
 
 	return nil
 }
+
+// swaps the algorithm, the data nodes are carried over with their states.
+func (lb *loadBalancer) algoSwitchHandler(conn net.Conn, msg *network.AlgoSwitchMsg) error {
+	ts := time.Now()
+	defer closeConn(conn)
+
+	name := msg.Algorithm
+
+	// built outside of the lock, the catalog may be read from disk
+	engine, err := newEngine(name)
+	if err != nil {
+		return fmt.Errorf("failed to switch algorithm: %v", err)
+	}
+
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+
+	// `requestCtr` and `avgRT` live on the nodes, the new algorithm picks
+	// them up as is
+	for _, node := range lb.engine.Queue() {
+		node.SetIndex(-1)
+		engine.NodeJoin(node)
+	}
+	lb.engine = engine
+
+	logger.Info("algorithm switched.", "algo", name)
+	lb.tel.Collect(&telemetry.LBEvent{
+		Type:      telemetry.LBAlgoSwitch,
+		Peer:      telemetry.PeerAdmin,
+		PeerID:    -1,
+		Timestamp: ts,
+		Duration:  time.Since(ts).Nanoseconds(),
+		Queue:     makeQueueString(lb),
+	})
+
+	return network.Send(conn, &network.RequestAcceptedMsg{})
+}
@@ -10,19 +10,15 @@ import (
 	"github.com/hn275/distributed-storage/internal"
 	"github.com/hn275/distributed-storage/internal/algo"
 	"github.com/hn275/distributed-storage/internal/config"
+	"github.com/hn275/distributed-storage/internal/database"
 	"github.com/hn275/distributed-storage/internal/telemetry"
+	"github.com/hn275/distributed-storage/internal/transport"
 )
 
 var (
 	logger   *slog.Logger = slog.Default()
 	globConf *config.Config
 	lbSrv    *loadBalancer
-
-	supportedAlgo = map[string]algo.LBAlgo{
-		algo.AlgoSimpleRoundRobin:  &algo.RoundRobin{},
-		algo.AlgoLeastResponseTime: &algo.LeastResponseTime{},
-		algo.AlgoLeastConnections:  &algo.LeastConnection{},
-	}
 )
 
 func main() {
@@ -37,26 +33,45 @@ func main() {
 	conf := &globConf.LoadBalancer
 	expName := globConf.Experiment.Name
 
+	if err := conf.CheckOptions(algo.Registered()); err != nil {
+		log.Fatalf("invalid config. %v", err)
+	}
+
 	// initializing the lb
-	var lbAlgo algo.LBAlgo
-	lbAlgo, ok := supportedAlgo[conf.Algorithm]
-	if !ok {
-		log.Fatalf("unsupported algorithm: [%s]", conf.Algorithm)
+	lbAlgo, err := newEngine(conf.Algorithm)
+	if err != nil {
+		log.Fatalf("failed to initialize algorithm. %v (supported: %v)",
+			err, algo.Registered())
 	}
 
-	lbAlgo.Initialize()
 	log.Printf("load balancing algorithm: %s\n", conf.Algorithm)
 
 	// telemetry
 	filePath := "tmp/output/lb/lb-" + expName + ".csv"
-	tel, err := telemetry.New(filePath, csvheaders)
+	tel, err := telemetry.New(filePath, telemetry.LBHeaders)
 	if err != nil {
 		panic(err)
 	}
 
 	defer tel.Done()
 
-	lbSrv, err = newLB(int(conf.LocalPort), lbAlgo, tel)
+	mode, err := conf.GetMode()
+	if err != nil {
+		log.Fatalf("invalid config. %v", err)
+	}
+
+	if conf.FailureDetector.Enabled() {
+		if err := conf.FailureDetector.Validate(); err != nil {
+			log.Fatalf("invalid config. %v", err)
+		}
+	}
+
+	tr, err := globConf.Security.NewTransport(transport.RoleLB)
+	if err != nil {
+		log.Fatalf("failed to initialize transport. %v", err)
+	}
+
+	lbSrv, err = newLB(conf.ListenAddr(), lbAlgo, tel, mode, tr, conf.Admission)
 	if err != nil {
 		log.Fatalf("failed to open listening socket: %W", err)
 	}
@@ -67,6 +82,8 @@ func main() {
 		"node started, waiting for services.",
 		"protocol", lbSrv.Addr().Network(),
 		"address", lbSrv.Addr(),
+		"mode", mode,
+		"secure", tr.Secure(),
 	)
 
 	// serving
@@ -76,6 +93,33 @@ func main() {
 	slog.Info("end of simulation")
 }
 
+// builds the algorithm `name` with the configured options, behind the
+// affinity layer if it's enabled.
+func newEngine(name string) (algo.LBAlgo, error) {
+	conf := &globConf.LoadBalancer
+	engine, err := algo.New(name, algo.Options{
+		Decode:  conf.OptionsDecoder(name),
+		Catalog: readCatalog,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.Affinity.TTL > 0 {
+		engine = algo.NewAffinity(engine, conf.Affinity.TTL)
+	}
+	return engine, nil
+}
+
+// returns the size of each file, keyed by the file name.
+func readCatalog() (map[string]uint64, error) {
+	fileIndex, err := database.NewFileIndex()
+	if err != nil {
+		return nil, err
+	}
+	return fileIndex.Catalog(), nil
+}
+
 func closeConn(conn net.Conn) {
 	if err := conn.Close(); err != nil && !errors.Is(err, io.EOF) {
 		logger.Error("failed to close connection",
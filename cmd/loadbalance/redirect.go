@@ -0,0 +1,99 @@
+package main
+
+import (
+	"slices"
+	"time"
+
+	"github.com/hn275/distributed-storage/internal/health"
+	"github.com/hn275/distributed-storage/internal/telemetry"
+)
+
+// how long the data node has to report on a redirected user. Past it the user
+// is presumed gone, it may have never dialed the node, and the slot is
+// released. Matches the users' own deadline.
+const redirectTimeout = 5 * time.Minute
+
+// a user redirected to a data node, released by the node's health check or
+// once it expires.
+type redirectLease struct {
+	timer *time.Timer
+}
+
+// Caller must hold `lbSrv.lock`.
+func (d *dataNode) expectRedirect(digest [32]byte) {
+	lease := &redirectLease{}
+	lease.timer = time.AfterFunc(redirectTimeout, func() {
+		d.expireRedirect(digest, lease)
+	})
+	d.redirects[digest] = append(d.redirects[digest], lease)
+}
+
+// matches a health check for `digest` with the oldest user redirected for
+// it. Returns false if there's none left. Caller must hold `lbSrv.lock`.
+func (d *dataNode) claimRedirect(digest [32]byte) bool {
+	leases := d.redirects[digest]
+	if len(leases) == 0 {
+		return false
+	}
+
+	leases[0].timer.Stop()
+	d.removeRedirect(digest, 0)
+	return true
+}
+
+// the node left, the slots are released along with it. Caller must hold
+// `lbSrv.lock`.
+func (d *dataNode) dropRedirects() {
+	for _, leases := range d.redirects {
+		for _, lease := range leases {
+			lease.timer.Stop()
+		}
+	}
+	clear(d.redirects)
+}
+
+func (d *dataNode) removeRedirect(digest [32]byte, i int) {
+	leases := slices.Delete(d.redirects[digest], i, i+1)
+	if len(leases) == 0 {
+		delete(d.redirects, digest)
+	} else {
+		d.redirects[digest] = leases
+	}
+}
+
+// releases the slot of a redirected user the node never reported on.
+func (d *dataNode) expireRedirect(digest [32]byte, lease *redirectLease) {
+	ts := time.Now()
+
+	lbSrv.lock.Lock()
+	defer lbSrv.lock.Unlock()
+
+	// claimed by a health check in the meantime, or the node left
+	i := slices.Index(d.redirects[digest], lease)
+	if i < 0 || d.state == health.Dead {
+		return
+	}
+
+	d.removeRedirect(digest, i)
+	d.release(digest)
+
+	if d.state == health.Alive {
+		if err := lbSrv.engine.Fix(d.index); err != nil {
+			d.log.Error("failed priority queue fixes.", "err", err)
+		}
+	}
+
+	d.log.Warn("redirected user never reported on, slot released.")
+	lbSrv.tel.Collect(&telemetry.LBEvent{
+		Type:      telemetry.LBRedirectExpired,
+		Peer:      telemetry.PeerDataNode,
+		PeerID:    int32(d.id),
+		Timestamp: ts,
+		Duration:  time.Since(ts).Nanoseconds(),
+		AvgRT:     d.avgRT,
+		ActiveReq: d.requestCtr,
+		Queue:     makeQueueString(lbSrv),
+	})
+
+	lbSrv.drainQueue()
+}
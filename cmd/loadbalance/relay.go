@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/hn275/distributed-storage/internal/network"
+	"github.com/hn275/distributed-storage/internal/telemetry"
+	"github.com/hn275/distributed-storage/internal/transport"
+)
+
+// how long a data node has to dial back, it may queue the request first.
+const relayAcceptTimeout = 5 * time.Minute
+
+// opens the listener the data node `node` dials instead of the user, on the
+// address the node reaches the LB on.
+func newRelayListener(node *dataNode) (*net.TCPListener, error) {
+	laddr := &net.TCPAddr{IP: node.LocalAddr().(*net.TCPAddr).IP}
+	ln, err := net.ListenTCP(network.ProtoTcp, laddr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ln.SetDeadline(time.Now().Add(relayAcceptTimeout)); err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	return ln, nil
+}
+
+// relays the data node's response to the user, once the node dials `ln`.
+// Closes the user's connection when done.
+func (lb *loadBalancer) relay(ln *net.TCPListener, user net.Conn, nodeID uint16) {
+	defer closeConn(user)
+
+	conn, err := ln.Accept()
+	ln.Close()
+	if err != nil {
+		logger.Error("data node never dialed the relay.",
+			"node-id", nodeID, "user", user.RemoteAddr(), "err", err)
+		return
+	}
+
+	node := lb.tr.Server(conn)
+	defer closeConn(node)
+
+	if err := lb.tr.Authorize(node, transport.RoleNode); err != nil {
+		logger.Error("relay peer not authorized.",
+			"node-id", nodeID, "remote_addr", node.RemoteAddr(), "err", err)
+		return
+	}
+
+	ts := time.Now()
+
+	// the user only sends the file request, the node answers and hangs up.
+	// On a plain transport both are TCP sockets, so the content is spliced in
+	// the kernel.
+	go io.Copy(node, user)
+
+	n, err := io.Copy(user, node)
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		logger.Error("relay failed.", "node-id", nodeID, "err", err)
+	}
+
+	lb.lock.Lock()
+	queue := makeQueueString(lb)
+	lb.lock.Unlock()
+
+	lb.tel.Collect(&telemetry.LBEvent{
+		Type:      telemetry.LBRelay,
+		Peer:      telemetry.PeerDataNode,
+		PeerID:    int32(nodeID),
+		Timestamp: ts,
+		Duration:  time.Since(ts).Nanoseconds(),
+		Queue:     queue,
+		Bytes:     uint64(n),
+	})
+}
@@ -0,0 +1,177 @@
+// sim runs the experiment of the config file through the discrete-event
+// simulator, for each of the given algorithms. The events are written with
+// the load balancer's CSV columns, to tmp/output/lb/lb-<name>-sim-<algo>.csv.
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hn275/distributed-storage/internal"
+	"github.com/hn275/distributed-storage/internal/algo"
+	"github.com/hn275/distributed-storage/internal/config"
+	"github.com/hn275/distributed-storage/internal/database"
+	"github.com/hn275/distributed-storage/internal/sim"
+	"github.com/hn275/distributed-storage/internal/telemetry"
+)
+
+func main() {
+	var (
+		algorithms string
+		bandwidth  float64
+		rate       float64
+		seed       int64
+	)
+
+	flag.StringVar(&algorithms, "algo", "", "comma separated algorithms, defaults to the configured one")
+	flag.Float64Var(&bandwidth, "bandwidth", 100e6, "transfer rate of the data nodes, in bytes per second")
+	flag.Float64Var(&rate, "rate", 0, "poisson arrivals per second, requests are spread over the user interval if 0")
+	flag.Int64Var(&seed, "seed", 1, "seed of the arrivals and service times")
+	flag.Parse()
+
+	configPath := internal.EnvOrDefault("CONFIG_PATH", config.DefaultConfigPath)
+	conf, err := config.NewConfig(configPath)
+	if err != nil {
+		log.Fatalf("failed to read config. %v", err)
+	}
+
+	fileIndex, err := database.NewFileIndex()
+	if err != nil {
+		log.Fatalf("failed to read file index. %v", err)
+	}
+
+	requests, err := makeRequests(conf, fileIndex)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var arrivals sim.Arrivals = sim.Uniform{
+		Window: time.Duration(conf.User.Interval) * time.Second,
+	}
+	if rate > 0 {
+		arrivals = sim.Poisson{Rate: rate}
+	}
+
+	if err := conf.LoadBalancer.CheckOptions(algo.Registered()); err != nil {
+		log.Fatalf("invalid config. %v", err)
+	}
+
+	names := []string{conf.LoadBalancer.Algorithm}
+	if algorithms != "" {
+		names = strings.Split(algorithms, ",")
+	}
+
+	fmt.Printf("%-24s %8s %12s %12s %12s %12s\n",
+		"algo", "requests", "mean", "p50", "p99", "makespan")
+
+	for _, name := range names {
+		engine, err := algo.New(name, algo.Options{
+			Decode: conf.LoadBalancer.OptionsDecoder(name),
+			Catalog: func() (map[string]uint64, error) {
+				return fileIndex.Catalog(), nil
+			},
+		})
+		if err != nil {
+			log.Fatalf("failed to initialize algorithm. %v (supported: %v)",
+				err, algo.Registered())
+		}
+
+		if ttl := conf.LoadBalancer.Affinity.TTL; ttl > 0 {
+			engine = algo.NewAffinity(engine, ttl)
+		}
+
+		filePath := fmt.Sprintf("tmp/output/lb/lb-%s-sim-%s.csv",
+			conf.Experiment.Name, name)
+		tel, err := telemetry.New(filePath, telemetry.LBHeaders)
+		if err != nil {
+			panic(err)
+		}
+
+		result, err := sim.Run(sim.Config{
+			Engine:    engine,
+			Nodes:     makeNodes(conf, bandwidth),
+			Requests:  requests,
+			Arrivals:  arrivals,
+			Scheduler: conf.Cluster.Scheduler,
+			Seed:      seed,
+		}, tel)
+		tel.Done()
+
+		if err != nil {
+			log.Fatalf("simulation of %s failed. %v", name, err)
+		}
+
+		printResult(name, result)
+
+		// broken down by priority class, if there's more than one
+		if len(result.Classes) > 1 {
+			for _, class := range slices.Sorted(maps.Keys(result.Classes)) {
+				printResult(fmt.Sprintf("  class %d", class), result.Classes[class])
+			}
+		}
+	}
+}
+
+func printResult(name string, result *sim.Result) {
+	fmt.Printf("%-24s %8d %12v %12v %12v %12v\n",
+		name, len(result.Latencies), result.Mean(),
+		result.Percentile(50), result.Percentile(99), result.Makespan)
+}
+
+// the data nodes as the cluster starts them.
+func makeNodes(conf *config.Config, bandwidth float64) []sim.Node {
+	nodes := make([]sim.Node, conf.Cluster.Node)
+	for i := range nodes {
+		id := uint16(i)
+
+		// weights set in the load balancer config takes precedence
+		weight := conf.Cluster.GetWeight(id)
+		if w, ok := conf.LoadBalancer.Weights[id]; ok {
+			weight = w
+		}
+
+		nodes[i] = sim.Node{
+			ID:       id,
+			Weight:   weight,
+			Capacity: conf.Cluster.GetCapacity(id),
+			Service: sim.Linear{
+				Overhead:  conf.Experiment.GetOverhead(id),
+				Bandwidth: bandwidth,
+			},
+		}
+	}
+	return nodes
+}
+
+// the requests of the user simulation.
+func makeRequests(conf *config.Config, fileIndex *database.FileIndex) ([]sim.Request, error) {
+	sizes := fileIndex.Catalog()
+	files := conf.User.GetFiles(fileIndex)
+	requests := make([]sim.Request, 0)
+
+	// in a set order, so runs are reproducible
+	for _, fileName := range slices.Sorted(maps.Keys(files)) {
+		freq := files[fileName]
+		key, err := hex.DecodeString(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("invalid file hash: %s", fileName)
+		}
+
+		for range freq {
+			requests = append(requests, sim.Request{
+				Key:    key,
+				Size:   sizes[fileName],
+				Client: conf.User.GetClientID(len(requests)),
+				Class:  conf.User.GetClass(sizes[fileName]),
+			})
+		}
+	}
+
+	return requests, nil
+}
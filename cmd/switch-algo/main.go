@@ -0,0 +1,52 @@
+// switch-algo swaps the load balancing algorithm of a running load balancer,
+// without dropping the data nodes.
+package main
+
+import (
+	"flag"
+	"log"
+	"log/slog"
+
+	"github.com/hn275/distributed-storage/internal/network"
+	"github.com/hn275/distributed-storage/internal/transport"
+)
+
+func main() {
+	var lbNodeAddr, algorithm, certDir string
+	flag.StringVar(&lbNodeAddr, "lbaddr", "127.0.0.1:8000", "address of the loadbalancer")
+	flag.StringVar(&algorithm, "algo", "", "algorithm to switch to")
+	flag.StringVar(&certDir, "certs", "", "directory of the certificates, if the load balancer runs the tls transport")
+	flag.Parse()
+
+	if algorithm == "" {
+		log.Fatalf("invalid algorithm name: [%s]", algorithm)
+	}
+
+	// only the admin is allowed to switch the algorithm
+	tr := transport.Plain()
+	if certDir != "" {
+		var err error
+		if tr, err = transport.New(certDir, transport.RoleAdmin); err != nil {
+			log.Fatalf("failed to load certificates: %v", err)
+		}
+	}
+
+	lbConn, err := tr.Dial(lbNodeAddr, transport.RoleLB)
+	if err != nil {
+		log.Fatalf("failed to dial load balancer: %v", err)
+	}
+
+	defer lbConn.Close()
+
+	if err := network.Send(lbConn, &network.AlgoSwitchMsg{Algorithm: algorithm}); err != nil {
+		log.Fatalf("failed to send switch: %v", err)
+	}
+
+	// the load balancer closes the connection without a reply on failures
+	status, err := network.Receive(lbConn)
+	if _, ok := status.(*network.RequestAcceptedMsg); err != nil || !ok {
+		log.Fatalf("switch to [%s] rejected, see the load balancer logs.", algorithm)
+	}
+
+	slog.Info("algorithm switched.", "algo", algorithm)
+}
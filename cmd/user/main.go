@@ -23,6 +23,7 @@ import (
 	"github.com/hn275/distributed-storage/internal/database"
 	"github.com/hn275/distributed-storage/internal/network"
 	"github.com/hn275/distributed-storage/internal/telemetry"
+	"github.com/hn275/distributed-storage/internal/transport"
 	"lukechampine.com/blake3"
 )
 
@@ -32,9 +33,24 @@ const (
 	timeStampFormat = "15:04:05.000"
 )
 
+// statuses of the failed requests, on top of the data node's
+const (
+	statusLBBusy    = "lb-busy"
+	statusTruncated = "truncated"
+	statusIntegrity = "integrity-violation"
+	statusTimeout   = "timeout"
+	statusError     = "error"
+)
+
 var (
-	lbNodeAddr     string
-	shutdownSignal = [...]byte{network.ShutdownSig}
+	errServerBusy = errors.New("load balancer busy")
+	errTruncated  = errors.New("file content cut short")
+	errIntegrity  = errors.New("file integrity violation")
+
+	lbNodeAddr string
+	listenAddr string // of the listeners the data nodes dial
+	mode       string // of the load balancer
+	tr         *transport.Transport
 )
 
 type ClientTimeData struct {
@@ -42,6 +58,7 @@ type ClientTimeData struct {
 	size      int64
 	timeStart time.Time
 	timeEnd   time.Time
+	status    string
 }
 
 // Row implements telemetry.Record.
@@ -53,6 +70,7 @@ func (c *ClientTimeData) Row() []string {
 		strconv.FormatInt(c.timeStart.UnixNano(), 10),    //time-start(ns)
 		c.timeEnd.Format(timeStampFormat),                // time-start
 		strconv.FormatInt(c.timeEnd.UnixNano(), 10),      //time-end(ns)
+		c.status, // status
 	}
 }
 
@@ -74,12 +92,23 @@ func main() {
 		panic(err)
 	}
 
+	listenAddr = conf.User.ListenAddr()
+	mode, err = conf.LoadBalancer.GetMode()
+	if err != nil {
+		panic(err)
+	}
+
+	tr, err = conf.Security.NewTransport(transport.RoleUser)
+	if err != nil {
+		panic(err)
+	}
+
 	// telemetry
 	tel, err := telemetry.New(
 		fmt.Sprintf("%s/client-%s.csv", outputDir, conf.Experiment.Name),
 		[]string{
 			"duration", "size", "time-start",
-			"time-start(ns)", "time-end", "time-end(ns)"},
+			"time-start(ns)", "time-end", "time-end(ns)", "status"},
 	)
 
 	if err != nil {
@@ -97,6 +126,7 @@ func main() {
 	wg := new(sync.WaitGroup)
 
 	files := conf.User.GetFiles(fileIndex)
+	sizes := fileIndex.Catalog()
 
 	clientIdx := 0
 	// requesting files
@@ -105,13 +135,16 @@ func main() {
 			continue
 		}
 
-		slog.Info("requesting file.", "file-name", fileName, "freq", freq)
+		class := conf.User.GetClass(sizes[fileName])
+		slog.Info("requesting file.",
+			"file-name", fileName, "freq", freq, "class", class)
 
 		wg.Add(freq)
 
 		for range freq {
 			// pass in global count variable
-			go runSim(fileName, wg, clientIdx, conf.User.Interval, tel)
+			clientID := conf.User.GetClientID(clientIdx)
+			go runSim(fileName, wg, clientIdx, clientID, class, conf.User.Interval, tel)
 			// increment the global count variable
 			clientIdx++
 		}
@@ -122,14 +155,14 @@ func main() {
 
 	// send shutdown signal to load balancer
 	// open socket to load balancer
-	lbConn, err := net.Dial(network.ProtoTcp4, lbNodeAddr)
+	lbConn, err := tr.Dial(lbNodeAddr, transport.RoleLB)
 	if err != nil {
 		panic(err)
 	}
 
 	defer lbConn.Close()
 
-	if _, err := lbConn.Write(shutdownSignal[:]); err != nil {
+	if err := network.Send(lbConn, &network.ShutdownSigMsg{}); err != nil {
 		panic(err)
 	}
 
@@ -141,7 +174,10 @@ type Result struct {
 	err  error
 }
 
-func runSim(fileHash string, wg *sync.WaitGroup, clientIdx int, interval uint32, tel *telemetry.Telemetry) {
+func runSim(
+	fileHash string, wg *sync.WaitGroup, clientIdx int, clientID uint64,
+	class uint8, interval uint32, tel *telemetry.Telemetry,
+) {
 	defer wg.Done()
 
 	// sleep for a random number of seconds in [0, interval]
@@ -155,116 +191,225 @@ func runSim(fileHash string, wg *sync.WaitGroup, clientIdx int, interval uint32,
 
 	slog.Info("request sent.", "file-name", fileHash, "client-id", clientIdx)
 
-	doneChan := make(chan error, 1)
+	doneChan := make(chan Result, 1)
+	timeStart := time.Now()
 
+	// the record is built by the request, and only collected by the receiver
 	go func() {
-		timeStart := time.Now()
-
-		fileSize, err := request(fileHash)
+		fileSize, err := request(fileHash, clientID, class)
 		if err != nil {
-			doneChan <- err
+			doneChan <- Result{err: err}
 			return
 		}
 
 		// Caputure request time for this client
-		dur := time.Since(timeStart)
-		timeEnd := timeStart.Add(dur)
-
-		rec := &ClientTimeData{
-			duration:  dur,
+		duration := time.Since(timeStart)
+		doneChan <- Result{tele: ClientTimeData{
+			duration:  duration,
 			size:      fileSize,
 			timeStart: timeStart,
-			timeEnd:   timeEnd,
-		}
-
-		tel.Collect(rec)
+			timeEnd:   timeStart.Add(duration),
+			status:    network.StatusText(network.StatusOK),
+		}}
 
 		slog.Info("file request.",
-			"file-size", humanize.Bytes(uint64(rec.size)),
-			"dur", rec.duration)
-
-		doneChan <- nil
+			"file-size", humanize.Bytes(uint64(fileSize)),
+			"dur", duration)
 	}()
 
+	// failed requests are recorded with a duration and a size of 0
+	var rec *ClientTimeData
 	select {
-	case err := <-doneChan:
-		if err != nil {
+	case res := <-doneChan:
+		rec = &res.tele
+		if res.err != nil {
 			slog.Error("request failed.",
 				"file-hash", fileHash,
-				"err", err)
+				"err", res.err)
+			rec = &ClientTimeData{
+				timeStart: timeStart,
+				timeEnd:   time.Now(),
+				status:    errorStatus(res.err),
+			}
 		}
-		return
 
 	case <-ctx.Done():
 		slog.Error("request timed out.", "client", clientIdx)
-		return
+		rec = &ClientTimeData{
+			timeStart: timeStart,
+			timeEnd:   time.Now(),
+			status:    statusTimeout,
+		}
+	}
+
+	tel.Collect(rec)
+}
+
+// returns the status of a failed request, as written in the telemetry.
+func errorStatus(err error) string {
+	var statusErr *network.StatusError
+	switch {
+	case errors.As(err, &statusErr):
+		return network.StatusText(statusErr.Status)
+	case errors.Is(err, errServerBusy):
+		return statusLBBusy
+	case errors.Is(err, errTruncated):
+		return statusTruncated
+	case errors.Is(err, errIntegrity):
+		return statusIntegrity
+	default:
+		return statusError
 	}
 }
 
 // request the file, returns (file size, error)
-func request(fileHash string) (int64, error) {
+func request(fileHash string, clientID uint64, class uint8) (int64, error) {
 
-	// open listener for data node
-	// open a new port for user to dial
-	soc, err := makeListener(network.ProtoTcp4, network.RandomLocalPort)
-	if err != nil {
-		return 0, err
-	}
+	// open listener for data node, unless the LB relays the response
+	var (
+		soc  net.Listener
+		addr *net.TCPAddr
+	)
+
+	if mode == config.ModeDirect {
+		// open a new port for user to dial
+		var err error
+		soc, err = makeListener(network.ProtoTcp, listenAddr)
+		if err != nil {
+			return 0, err
+		}
 
-	defer soc.Close()
+		defer soc.Close()
+		addr = soc.Addr().(*net.TCPAddr)
+	}
 
 	// open socket to load balancer
-	lbConn, err := net.Dial(network.ProtoTcp4, lbNodeAddr)
+	lbConn, err := tr.Dial(lbNodeAddr, transport.RoleLB)
 	if err != nil {
 		return 0, fmt.Errorf("failed to dial load balancer: %v", err)
 	}
 
 	defer lbConn.Close()
 
-	ping := [16]byte{network.UserNodeJoin}
-	if err := network.AddrToBytes(soc.Addr(), ping[1:]); err != nil {
-		return 0, err
+	// the file digest, the client id and the priority class are sent along
+	// so the LB can route and schedule on them
+	var digest [32]byte
+	if _, err := hex.Decode(digest[:], []byte(fileHash)); err != nil {
+		return 0, fmt.Errorf("invalid file hash: %s", fileHash)
 	}
 
-	if _, err := lbConn.Write(ping[:]); err != nil {
+	ping := &network.UserNodeJoinMsg{
+		Addr:     addr,
+		Digest:   digest,
+		ClientID: clientID,
+		Class:    class,
+	}
+
+	if err := network.Send(lbConn, ping); err != nil {
 		return 0, fmt.Errorf("failed ping load balancer: %v", err)
 	}
+
+	// the LB either accepts the request, or turns it down if it's overloaded.
+	// In redirect mode, a request accepted is queued, the data node's address
+	// follows once it's dispatched.
+	status, err := network.Receive(lbConn)
+	if _, queued := status.(*network.RequestAcceptedMsg); queued && mode == config.ModeRedirect {
+		status, err = network.Receive(lbConn)
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to read load balancer response: %v", err)
+	}
+
+	switch status := status.(type) {
+	case *network.RequestAcceptedMsg:
+	case *network.RedirectMsg:
+		lbConn.Close()
+		return redirect(status.Addr, ping, digest)
+	case *network.ServerBusyMsg:
+		return 0, errServerBusy
+	default:
+		return 0, fmt.Errorf("unexpected load balancer response: %d", status.Type())
+	}
+
+	if soc == nil {
+		return fetch(lbConn, digest)
+	}
+
 	lbConn.Close()
 
 	// datanode connects
-	dataConn, err := soc.Accept()
+	conn, err := soc.Accept()
 	if err != nil {
 		return 0, err
 	}
 
+	dataConn := tr.Server(conn)
 	defer dataConn.Close()
 
-	// sending file name + pub key
-	// 64 bytes, 32 byte file hash, 32 byte pub key
-	var buf [64]byte
-	if _, err := hex.Decode(buf[:32], []byte(fileHash)); err != nil {
-		return 0, fmt.Errorf("invalid file hash: %s", fileHash)
+	if err := tr.Authorize(dataConn, transport.RoleNode); err != nil {
+		return 0, fmt.Errorf("data node not authorized: %v", err)
+	}
+
+	return fetch(dataConn, digest)
+}
+
+// dials the data node the LB picked, and pings it as the LB would have.
+func redirect(
+	addr *net.TCPAddr, ping *network.UserNodeJoinMsg, digest [32]byte,
+) (int64, error) {
+	conn, err := net.DialTCP(network.ProtoTcp, nil, addr)
+	if err != nil {
+		return 0, fmt.Errorf("failed to dial data node: %v", err)
 	}
 
-	copy(buf[32:], crypto.UserPublicKey[:])
+	dataConn := tr.Client(conn, transport.RoleNode)
+	defer dataConn.Close()
+
+	if err := network.Send(dataConn, ping); err != nil {
+		return 0, fmt.Errorf("failed ping data node: %v", err)
+	}
 
-	if _, err := dataConn.Write(buf[:64]); err != nil {
+	return fetch(dataConn, digest)
+}
+
+// requests the file `digest` on `dataConn`, returns (file size, error)
+func fetch(dataConn net.Conn, digest [32]byte) (int64, error) {
+	// sending file name + pub key
+	fileReq := &network.FileRequestMsg{Digest: digest, PubKey: crypto.UserPublicKey}
+	if err := network.Send(dataConn, fileReq); err != nil {
 		return 0, fmt.Errorf("failed to write to datanode; %v", err)
 	}
 
+	// the data node tells whether the file follows, and its size
+	res, err := network.Receive(dataConn)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read data node response: %v", err)
+	}
+
+	fileRes, ok := res.(*network.FileResponseMsg)
+	if !ok {
+		return 0, fmt.Errorf("unexpected data node response: %d", res.Type())
+	}
+
+	if err := fileRes.Err(); err != nil {
+		return 0, err
+	}
+
 	// write responses to hasher
 	h := blake3.New(crypto.DigestSize, crypto.UserPublicKey[:])
 
-	byteCopied, err := io.Copy(h, dataConn)
-	if err != nil {
+	byteCopied, err := io.CopyN(h, dataConn, int64(fileRes.Length))
+	if errors.Is(err, io.EOF) {
+		return byteCopied, fmt.Errorf("%w: %d of %d bytes",
+			errTruncated, byteCopied, fileRes.Length)
+	} else if err != nil {
 		return 0, fmt.Errorf("failed to write to hasher; %v", err)
 	}
 
 	// hash the content then check the digest against the file name
-	digest := h.Sum(nil)
-	precomputedDigest := buf[:32]
-	if !byteEqual(digest, precomputedDigest) {
-		return 0, errors.New("file integrity violation")
+	if !byteEqual(h.Sum(nil), digest[:]) {
+		return 0, errIntegrity
 	}
 
 	return byteCopied, nil
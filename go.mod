@@ -11,7 +11,6 @@ require (
 )
 
 require (
-	github.com/Jeffail/tunny v0.1.4
 	github.com/dustin/go-humanize v1.0.1
 	golang.org/x/crypto v0.35.0
 	lukechampine.com/blake3 v1.3.0
@@ -0,0 +1,200 @@
+package algo
+
+import (
+	"time"
+)
+
+// Affinity implements ClientLBAlgo, Observer, Completer and KeyedLBAlgo.
+//
+// Sticky routing in front of any algorithm: a client is sent back to the
+// node that served it last, as long as the node is still around, isn't
+// saturated (active requests below its capacity) and the client was seen
+// within the last `TTL`. Otherwise the `Inner` algorithm picks the node, which
+// becomes the client's new sticky node. Requests without a client go to the
+// inner algorithm directly.
+//
+// Sticky picks are recorded by an inner Assigner. The sticky requests of
+// other inner algorithms are never completed, as the inner algorithm doesn't
+// know of them. Latencies are observed either way, they tell of the node.
+type Affinity struct {
+	Inner LBAlgo
+	TTL   time.Duration
+
+	clients   map[uint64]*stickyEntry
+	nextSweep time.Time
+	// node handed out by the inner algorithm, to be put back into it
+	borrowed QueueNode
+	// sticky requests the inner algorithm doesn't know of, to be completed
+	unassigned map[stickyRequest]int
+
+	now func() time.Time
+}
+
+type stickyEntry struct {
+	node    QueueNode
+	expires time.Time
+}
+
+type stickyRequest struct {
+	node QueueNode
+	key  string
+}
+
+// NewAffinity wraps the initialized algorithm `inner`.
+func NewAffinity(inner LBAlgo, ttl time.Duration) *Affinity {
+	a := &Affinity{Inner: inner, TTL: ttl}
+	a.Initialize()
+	return a
+}
+
+// only initializes the affinity layer, `Inner` is already initialized.
+func (a *Affinity) Initialize() {
+	if a.now == nil {
+		a.now = time.Now
+	}
+	a.clients = make(map[uint64]*stickyEntry)
+	a.nextSweep = a.now().Add(a.TTL)
+	a.borrowed = nil
+	a.unassigned = make(map[stickyRequest]int)
+}
+
+func (a *Affinity) NodeJoin(node QueueNode) {
+	a.Inner.NodeJoin(node)
+}
+
+func (a *Affinity) NodeLeave(node QueueNode) {
+	a.Inner.NodeLeave(node)
+	for client, entry := range a.clients {
+		if entry.node == node {
+			delete(a.clients, client)
+		}
+	}
+	for req := range a.unassigned {
+		if req.node == node {
+			delete(a.unassigned, req)
+		}
+	}
+}
+
+func (a *Affinity) GetNode() (QueueNode, error) {
+	return a.getInner(nil)
+}
+
+func (a *Affinity) GetNodeByKey(key []byte) (QueueNode, error) {
+	return a.getInner(key)
+}
+
+func (a *Affinity) GetNodeByClient(client uint64, key []byte) (QueueNode, error) {
+	now := a.now()
+	a.sweep(now)
+
+	entry, ok := a.clients[client]
+	if ok && now.Before(entry.expires) && !saturated(entry.node) {
+		entry.expires = now.Add(a.TTL)
+		a.assign(entry.node, key)
+		return entry.node, nil
+	}
+
+	node, err := a.getInner(key)
+	if err != nil {
+		return nil, err
+	}
+
+	a.clients[client] = &stickyEntry{node, now.Add(a.TTL)}
+	return node, nil
+}
+
+// the node goes back into the inner algorithm if it came from it, a sticky
+// node never left, its position is fixed up for the new request instead.
+func (a *Affinity) PutNode(node QueueNode) {
+	if node == a.borrowed {
+		a.borrowed = nil
+		a.Inner.PutNode(node)
+		return
+	}
+	a.Inner.Fix(node.Index())
+}
+
+func (a *Affinity) Fix(i int) error {
+	return a.Inner.Fix(i)
+}
+
+func (a *Affinity) Queue() []QueueNode {
+	return a.Inner.Queue()
+}
+
+func (a *Affinity) Snapshot() []NodeState {
+	return a.Inner.Snapshot()
+}
+
+// Observer implementation, forwarded to the inner algorithm.
+func (a *Affinity) Observe(node QueueNode, key []byte, latency time.Duration) {
+	if observer, ok := a.Inner.(Observer); ok {
+		observer.Observe(node, key, latency)
+	}
+}
+
+// Completer implementation, forwarded to the inner algorithm if it knows of
+// the request.
+func (a *Affinity) Complete(node QueueNode, key []byte) {
+	req := stickyRequest{node, string(key)}
+	if count := a.unassigned[req]; count != 0 {
+		if count == 1 {
+			delete(a.unassigned, req)
+		} else {
+			a.unassigned[req] = count - 1
+		}
+		return
+	}
+	if completer, ok := a.Inner.(Completer); ok {
+		completer.Complete(node, key)
+	}
+}
+
+// records a sticky pick in the inner algorithm, or keeps it from being
+// completed there.
+func (a *Affinity) assign(node QueueNode, key []byte) {
+	if assigner, ok := a.Inner.(Assigner); ok {
+		assigner.Assign(node, key)
+		return
+	}
+	a.unassigned[stickyRequest{node, string(key)}]++
+}
+
+func (a *Affinity) getInner(key []byte) (QueueNode, error) {
+	var (
+		node QueueNode
+		err  error
+	)
+
+	if keyed, ok := a.Inner.(KeyedLBAlgo); ok && key != nil {
+		node, err = keyed.GetNodeByKey(key)
+	} else {
+		node, err = a.Inner.GetNode()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	a.borrowed = node
+	return node, nil
+}
+
+// drops the expired clients, at most once per TTL.
+func (a *Affinity) sweep(now time.Time) {
+	if now.Before(a.nextSweep) {
+		return
+	}
+
+	for client, entry := range a.clients {
+		if !now.Before(entry.expires) {
+			delete(a.clients, client)
+		}
+	}
+	a.nextSweep = now.Add(a.TTL)
+}
+
+func saturated(node QueueNode) bool {
+	return node.ActiveRequests() >= uint64(nodeCapacity(node))
+}
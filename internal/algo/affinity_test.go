@@ -0,0 +1,176 @@
+package algo
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func makeTestAffinity(clock *testClock, nodeCount int) (*Affinity, []*testCapacitated) {
+	lc := &LeastConnection{}
+	lc.Initialize()
+
+	a := &Affinity{Inner: lc, TTL: time.Second, now: clock.now}
+	a.Initialize()
+
+	nodes := make([]*testCapacitated, nodeCount)
+	for i := range nodes {
+		nodes[i] = &testCapacitated{testLoaded{testNodeID{nodeID: uint16(i)}, 0}, 2}
+		a.NodeJoin(nodes[i])
+	}
+
+	return a, nodes
+}
+
+// same steps as the load balancer's dispatch.
+func getTestClient(t *testing.T, a *Affinity, client uint64) *testCapacitated {
+	node, err := a.GetNodeByClient(client, nil)
+	assert.Nil(t, err)
+
+	node.(*testCapacitated).active++
+	a.PutNode(node)
+	return node.(*testCapacitated)
+}
+
+func TestAffinitySticky(t *testing.T) {
+	clock := &testClock{time.Unix(0, 0)}
+	a, nodes := makeTestAffinity(clock, 3)
+
+	first := getTestClient(t, a, 1)
+	other := getTestClient(t, a, 2)
+	assert.NotSame(t, first, other)
+
+	// back to the same node, though it's not the least loaded
+	assert.Same(t, first, getTestClient(t, a, 1))
+	assert.Equal(t, uint64(2), first.active)
+
+	// saturated, the inner algorithm picks the new sticky node
+	next := getTestClient(t, a, 1)
+	assert.NotSame(t, first, next)
+	assert.Same(t, next, getTestClient(t, a, 1))
+
+	// sticky nodes never leave the inner algorithm
+	assert.Equal(t, len(nodes), len(a.Queue()))
+	assert.Equal(t, len(nodes), len(a.Snapshot()))
+}
+
+func TestAffinityTTL(t *testing.T) {
+	clock := &testClock{time.Unix(0, 0)}
+	a, _ := makeTestAffinity(clock, 3)
+	first := getTestClient(t, a, 1)
+
+	// every request refreshes the TTL
+	for range 3 {
+		clock.t = clock.t.Add(900 * time.Millisecond)
+		first.active = 0
+		assert.Nil(t, a.Fix(first.index))
+		assert.Same(t, first, getTestClient(t, a, 1))
+	}
+
+	// expired, swept, and the client is scheduled by the inner algorithm
+	clock.t = clock.t.Add(2 * time.Second)
+	assert.NotSame(t, first, getTestClient(t, a, 2))
+	assert.Equal(t, 1, len(a.clients))
+	assert.NotSame(t, first, getTestClient(t, a, 1))
+}
+
+func TestAffinityNodeLeave(t *testing.T) {
+	clock := &testClock{time.Unix(0, 0)}
+	a, nodes := makeTestAffinity(clock, 2)
+
+	first := getTestClient(t, a, 1)
+	a.NodeLeave(first)
+	assert.Equal(t, 0, len(a.clients))
+	assert.Equal(t, 1, len(a.Queue()))
+
+	next := getTestClient(t, a, 1)
+	assert.NotSame(t, first, next)
+	assert.Contains(t, []*testCapacitated{nodes[0], nodes[1]}, next)
+
+	// requests without a client go straight to the inner algorithm
+	node, err := a.GetNode()
+	assert.Nil(t, err)
+	a.PutNode(node)
+	assert.Same(t, next, node)
+	assert.Equal(t, 1, len(a.Queue()))
+}
+
+func TestAffinityLeastBytes(t *testing.T) {
+	var (
+		small = []byte{0x01}
+		large = []byte{0x02}
+	)
+
+	lb := &LeastOutstandingBytes{
+		Sizes: map[string]uint64{
+			hex.EncodeToString(small): 1 << 8,
+			hex.EncodeToString(large): 1 << 30,
+		},
+	}
+	lb.Initialize()
+
+	clock := &testClock{time.Unix(0, 0)}
+	a := &Affinity{Inner: lb, TTL: time.Second, now: clock.now}
+	a.Initialize()
+
+	nodes := []*testCapacitated{
+		{testLoaded{testNodeID{nodeID: 0}, 0}, 4},
+		{testLoaded{testNodeID{nodeID: 1}, 0}, 4},
+	}
+	for _, node := range nodes {
+		a.NodeJoin(node)
+	}
+
+	first, err := a.GetNodeByClient(1, large)
+	assert.Nil(t, err)
+	a.PutNode(first)
+
+	// the sticky request is charged to the node as well
+	sticky, err := a.GetNodeByClient(1, small)
+	assert.Nil(t, err)
+	a.PutNode(sticky)
+	assert.Same(t, first, sticky)
+	assert.Equal(t, uint64(1<<30+1<<8), lb.outstanding[first])
+
+	// and done without wiping the large file's bytes
+	a.Complete(sticky, small)
+	assert.Equal(t, uint64(1<<30), lb.outstanding[first])
+
+	node, err := a.GetNodeByClient(2, small)
+	assert.Nil(t, err)
+	assert.NotSame(t, first, node)
+}
+
+// completes the requests, without recording the picks.
+type testCompleter struct {
+	LeastConnection
+	completed int
+}
+
+func (tc *testCompleter) Complete(QueueNode, []byte) {
+	tc.completed++
+}
+
+func TestAffinityUnassigned(t *testing.T) {
+	inner := &testCompleter{}
+	inner.Initialize()
+
+	clock := &testClock{time.Unix(0, 0)}
+	a := &Affinity{Inner: inner, TTL: time.Second, now: clock.now}
+	a.Initialize()
+
+	node := &testCapacitated{testLoaded{testNodeID{nodeID: 0}, 0}, 4}
+	a.NodeJoin(node)
+
+	first := getTestClient(t, a, 1)
+	sticky := getTestClient(t, a, 1)
+	assert.Same(t, first, sticky)
+
+	// the sticky request is unknown to the inner algorithm
+	a.Complete(sticky, nil)
+	a.Complete(first, nil)
+	assert.Equal(t, 1, inner.completed)
+	assert.Equal(t, 0, len(a.unassigned))
+}
@@ -1,34 +1,170 @@
 package algo
 
-import "net"
+import (
+	"container/heap"
+	"net"
+	"sort"
+	"time"
+)
 
 const (
-	AlgoSimpleRoundRobin  = "simple-round-robin"
-	AlgoLeastResponseTime = "least-response-time"
-	AlgoLeastConnections  = "least-connections"
+	AlgoSimpleRoundRobin   = "simple-round-robin"
+	AlgoLeastResponseTime  = "least-response-time"
+	AlgoLeastConnections   = "least-connections"
+	AlgoWeightedRoundRobin = "weighted-round-robin"
+	AlgoPowerOfChoices     = "power-of-choices"
+	AlgoConsistentHash     = "consistent-hash"
+	AlgoBoundedLoadHash    = "bounded-load-hash"
+	AlgoRendezvous         = "rendezvous"
+	AlgoPeakEWMA           = "peak-ewma"
+	AlgoLeastBytes         = "least-outstanding-bytes"
+	AlgoSITA               = "sita"
+	AlgoLeastUtilization   = "least-utilization"
+	AlgoBandit             = "bandit"
 )
 
 type LBAlgo interface {
 	Initialize()
 	NodeJoin(QueueNode)
+	NodeLeave(QueueNode)
 	GetNode() (QueueNode, error)
 	PutNode(QueueNode)
 	Fix(int) error
 	Queue() []QueueNode
+	Snapshot() []NodeState
+}
+
+// NodeState is a point in time copy of a node's state, as seen by the
+// algorithm. Snapshots are ordered by `Position`: 0 is the node the algorithm
+// would pick next, for algorithms that don't route on keys.
+type NodeState struct {
+	ID       uint16
+	Active   uint64
+	Score    float64 // the value the algorithm orders the node on
+	Position int
+}
+
+// KeyedLBAlgo is implemented by algorithms that route on the requested key
+// (the file digest), rather than on the node states only.
+type KeyedLBAlgo interface {
+	LBAlgo
+	GetNodeByKey(key []byte) (QueueNode, error)
+}
+
+// ClientLBAlgo is implemented by algorithms that route on the identity of the
+// client, on top of the requested key.
+type ClientLBAlgo interface {
+	LBAlgo
+	GetNodeByClient(client uint64, key []byte) (QueueNode, error)
+}
+
+// Observer is implemented by algorithms that learn from the latency of the
+// requests served, `key` is the digest of the file served. Failed requests
+// say nothing of the node's speed, and aren't observed.
+type Observer interface {
+	Observe(node QueueNode, key []byte, latency time.Duration)
 }
 
+// Completer is implemented by algorithms that track the work handed to the
+// nodes, `key` is the digest of the file requested. Every request is
+// completed once, whether it was served or failed.
+type Completer interface {
+	Complete(node QueueNode, key []byte)
+}
+
+// Assigner is implemented by algorithms that record their picks, so requests
+// sent to a node picked outside of the algorithm (a sticky node) are recorded
+// as well, before they are completed.
+type Assigner interface {
+	Assign(node QueueNode, key []byte)
+}
+
+// QueueNode is a data node, as seen by the algorithms. The algorithms own
+// the ordering of the nodes, scoring them on the states reported here.
 type QueueNode interface {
 	net.Conn
-	Less(QueueNode) bool
 	SetIndex(i int)
+	Index() int
+	ID() uint16
+	ActiveRequests() uint64
+	AvgResponseTime() float64 // in nanoseconds
 }
 
+// Weighted is implemented by nodes that advertise a scheduling weight.
+type Weighted interface {
+	Weight() uint16
+}
+
+// Capacitated is implemented by nodes that advertise the number of requests
+// they can serve concurrently.
+type Capacitated interface {
+	Capacity() uint16
+}
+
+// removes `node` from `nodes`, keeping the order. Returns the position the
+// node was at, -1 if it wasn't found.
+func removeNode(nodes []QueueNode, node QueueNode) ([]QueueNode, int) {
+	for i, n := range nodes {
+		if n == node {
+			return append(nodes[:i], nodes[i+1:]...), i
+		}
+	}
+	return nodes, -1
+}
+
+// returns the states of the nodes, ordered by `less` (kept in the given
+// order if nil), and scored with `score`.
+func snapshot(nodes []QueueNode, less lessFunc, score func(QueueNode) float64) []NodeState {
+	ordered := append([]QueueNode(nil), nodes...)
+	if less != nil {
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return less(ordered[i], ordered[j])
+		})
+	}
+
+	states := make([]NodeState, len(ordered))
+	for i, node := range ordered {
+		states[i] = NodeState{
+			ID:       node.ID(),
+			Active:   node.ActiveRequests(),
+			Score:    score(node),
+			Position: i,
+		}
+	}
+
+	return states
+}
+
+func activeScore(node QueueNode) float64 {
+	return float64(node.ActiveRequests())
+}
+
+// orders the nodes of a heap, returns true if `left` should be picked before
+// `right`.
+type lessFunc func(left, right QueueNode) bool
+
 type priorityQueue []QueueNode
 
-// priorityQueue implements sort.Interface
-func (pq priorityQueue) Less(i, j int) bool {
-	left, right := pq[i], pq[j]
-	return left.Less(right)
+// heapQueue is a priorityQueue ordered by the algorithm's comparator.
+type heapQueue struct {
+	priorityQueue
+	less lessFunc
+}
+
+// removes the node from the heap, using its tracked index.
+func (hq *heapQueue) remove(node QueueNode) {
+	i := node.Index()
+	if i < 0 || i >= hq.Len() || hq.priorityQueue[i] != node {
+		return
+	}
+	heap.Remove(hq, i)
+	node.SetIndex(-1)
+}
+
+// heapQueue implements sort.Interface
+func (hq *heapQueue) Less(i, j int) bool {
+	left, right := hq.priorityQueue[i], hq.priorityQueue[j]
+	return hq.less(left, right)
 }
 
 // priorityQueue implements sort.Interface
@@ -61,13 +61,33 @@ func (t *testPQ) Close() error {
 	return nil
 }
 
-func (tpq *testPQ) Less(other QueueNode) bool {
-	otherNode := other.(*testPQ)
-	return tpq.float64 < otherNode.float64
+// Index implements QueueNode.
+func (t *testPQ) Index() int {
+	return t.index
+}
+
+// ID implements QueueNode.
+func (t *testPQ) ID() uint16 {
+	return 0
+}
+
+// ActiveRequests implements QueueNode.
+func (t *testPQ) ActiveRequests() uint64 {
+	return 0
+}
+
+// AvgResponseTime implements QueueNode.
+func (t *testPQ) AvgResponseTime() float64 {
+	return t.float64
+}
+
+func lessTestPQ(left, right QueueNode) bool {
+	return left.(*testPQ).float64 < right.(*testPQ).float64
 }
 
 func TestPriorityQueueSortInterface(t *testing.T) {
-	pq := make(priorityQueue, 7)
+	hq := heapQueue{make(priorityQueue, 7), lessTestPQ}
+	pq := hq.priorityQueue
 
 	pq[0] = &testPQ{1.0, 0}
 	pq[1] = &testPQ{1.1, 0}
@@ -81,16 +101,16 @@ func TestPriorityQueueSortInterface(t *testing.T) {
 	assert.Equal(t, len(pq), pq.Len())
 
 	// Less(i, j int) bool
-	assert.True(t, pq.Less(0, 1))
-	assert.True(t, pq.Less(0, 2))
-	assert.True(t, pq.Less(0, 3))
-	assert.False(t, pq.Less(1, 0))
-	assert.False(t, pq.Less(2, 0))
-	assert.False(t, pq.Less(3, 0))
+	assert.True(t, hq.Less(0, 1))
+	assert.True(t, hq.Less(0, 2))
+	assert.True(t, hq.Less(0, 3))
+	assert.False(t, hq.Less(1, 0))
+	assert.False(t, hq.Less(2, 0))
+	assert.False(t, hq.Less(3, 0))
 
 	// testing equality
-	assert.False(t, pq.Less(4, 5))
-	assert.False(t, pq.Less(5, 4))
+	assert.False(t, hq.Less(4, 5))
+	assert.False(t, hq.Less(5, 4))
 
 	// Swap(i, j int)
 	pq.Swap(0, 3)
@@ -100,12 +120,12 @@ func TestPriorityQueueSortInterface(t *testing.T) {
 
 func TestPriorityQueueHeapInterface(t *testing.T) {
 	const N = 1024
-	pq := make(priorityQueue, N)
+	pq := heapQueue{make(priorityQueue, N), lessTestPQ}
 	expectedValues := make(sort.Float64Slice, N)
 
 	for i := 0; i < N; i++ {
 		v := rand.Float64()
-		pq[i] = &testPQ{v, 0}
+		pq.priorityQueue[i] = &testPQ{v, 0}
 		expectedValues[i] = v
 	}
 
@@ -137,7 +157,7 @@ func TestPriorityQueueHeapInterface(t *testing.T) {
 // indexing is updated on queue update
 func TestPriorityQueueIndexMangement(t *testing.T) {
 	const N = 16
-	pq := make(priorityQueue, 0, N)
+	pq := heapQueue{make(priorityQueue, 0, N), lessTestPQ}
 
 	for i := 0; i < N; i++ {
 		v := rand.Float64()
@@ -145,7 +165,7 @@ func TestPriorityQueueIndexMangement(t *testing.T) {
 		heap.Push(&pq, node)
 	}
 
-	for i, node := range pq {
+	for i, node := range pq.priorityQueue {
 		assert.Equal(t, i, node.(*testPQ).index)
 	}
 
@@ -154,7 +174,7 @@ func TestPriorityQueueIndexMangement(t *testing.T) {
 	node.float64 = 0.0
 	pq.Push(node)
 
-	for i, node := range pq {
+	for i, node := range pq.priorityQueue {
 		assert.Equal(t, i, node.(*testPQ).index)
 	}
 }
@@ -0,0 +1,266 @@
+package algo
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+func init() {
+	Register(AlgoBandit, func(opts Options) (LBAlgo, error) {
+		b := &Bandit{}
+		if err := opts.Decode(b); err != nil {
+			return nil, err
+		}
+		return b, b.validate()
+	})
+}
+
+// Bandit implements LBAlgo, Observer and Assigner.
+//
+// Nodes are the arms of a multi-armed bandit, learning which node serves the
+// fastest from the response times of the completed requests. A response time
+// is mapped to a reward in (0, 1] with `LatencyScale` / (`LatencyScale` +
+// latency), so a node as fast as the scale is rewarded 0.5. Two policies are
+// supported:
+//   - ucb1: picks the node with the highest mean reward plus an exploration
+//     bonus of `Exploration` * sqrt(ln(total pulls) / node pulls). Untried
+//     nodes are picked first.
+//   - thompson: rewards are treated as fractional Bernoulli trials, each
+//     node's mean reward follows a Beta(`Prior`[0] + rewards, `Prior`[1] +
+//     trials - rewards) posterior. A mean is sampled for every node, the
+//     highest sample is picked.
+//
+// The active requests aren't accounted for, the policies only trade off
+// exploring against exploiting the fastest node.
+type Bandit struct {
+	Policy       string        `yaml:"policy"`
+	Exploration  float64       `yaml:"exploration"`
+	Prior        [2]float64    `yaml:"prior"`
+	LatencyScale time.Duration `yaml:"latency-scale"`
+
+	nodes []QueueNode
+	arms  map[QueueNode]*arm
+	pulls uint64 // total, over all nodes
+	rng   *rand.Rand
+}
+
+type arm struct {
+	pulls   uint64  // times the node was picked
+	trials  uint64  // completed requests observed
+	rewards float64 // sum of the rewards observed
+}
+
+const (
+	BanditUCB1     = "ucb1"
+	BanditThompson = "thompson"
+
+	defaultLatencyScale = 100 * time.Millisecond
+)
+
+var (
+	defaultExploration = math.Sqrt2
+	defaultPrior       = [2]float64{1.0, 1.0}
+)
+
+func (b *Bandit) Initialize() {
+	if b.Policy == "" {
+		b.Policy = BanditUCB1
+	}
+	if b.Exploration <= 0 {
+		b.Exploration = defaultExploration
+	}
+	if b.Prior[0] <= 0 || b.Prior[1] <= 0 {
+		b.Prior = defaultPrior
+	}
+	if b.LatencyScale <= 0 {
+		b.LatencyScale = defaultLatencyScale
+	}
+
+	b.nodes = make([]QueueNode, 0)
+	b.arms = make(map[QueueNode]*arm)
+	b.pulls = 0
+	if b.rng == nil {
+		b.rng = rand.New(rand.NewSource(rand.Int63()))
+	}
+}
+
+func (b *Bandit) validate() error {
+	switch b.Policy {
+	case "", BanditUCB1, BanditThompson:
+		return nil
+	default:
+		return fmt.Errorf("unsupported bandit policy [%s].", b.Policy)
+	}
+}
+
+func (b *Bandit) NodeJoin(node QueueNode) {
+	b.nodes = append(b.nodes, node)
+	b.arms[node] = &arm{}
+}
+
+func (b *Bandit) NodeLeave(node QueueNode) {
+	b.nodes, _ = removeNode(b.nodes, node)
+	if a, ok := b.arms[node]; ok {
+		b.pulls -= a.pulls
+		delete(b.arms, node)
+	}
+}
+
+func (b *Bandit) GetNode() (QueueNode, error) {
+	if len(b.nodes) == 0 {
+		return nil, errors.New("no node can be scheduled.")
+	}
+
+	var best QueueNode
+	switch b.Policy {
+	case BanditThompson:
+		best = b.sampleBest()
+	default:
+		best = b.ucbBest()
+	}
+
+	b.Assign(best, nil)
+	return best, nil
+}
+
+// Assigner implementation
+func (b *Bandit) Assign(node QueueNode, _ []byte) {
+	if a, ok := b.arms[node]; ok {
+		a.pulls++
+		b.pulls++
+	}
+}
+
+func (b *Bandit) PutNode(node QueueNode) {
+	// nop
+}
+
+func (b *Bandit) Fix(int) error {
+	// nop
+	return nil
+}
+
+func (b *Bandit) Queue() []QueueNode {
+	return b.nodes
+}
+
+// UCB1 nodes are scored by the probability of being picked next, 1 for the
+// next node. Thompson sampling nodes are scored by their posterior mean
+// reward, the snapshot draws no sample so it leaves the picks as they are.
+func (b *Bandit) Snapshot() []NodeState {
+	scores := make(map[QueueNode]float64, len(b.nodes))
+	if len(b.nodes) != 0 {
+		switch b.Policy {
+		case BanditThompson:
+			for _, node := range b.nodes {
+				alpha, beta := b.posterior(b.arms[node])
+				scores[node] = alpha / (alpha + beta)
+			}
+		default:
+			scores[b.ucbBest()] = 1.0
+		}
+	}
+
+	less := func(left, right QueueNode) bool {
+		return scores[left] > scores[right]
+	}
+
+	return snapshot(b.nodes, less, func(node QueueNode) float64 {
+		return scores[node]
+	})
+}
+
+// Observer implementation
+func (b *Bandit) Observe(node QueueNode, _ []byte, latency time.Duration) {
+	a, ok := b.arms[node]
+	if !ok {
+		return
+	}
+
+	scale := float64(b.LatencyScale)
+	a.trials++
+	a.rewards += scale / (scale + float64(max(latency, 0)))
+}
+
+func (b *Bandit) ucbBest() QueueNode {
+	best, bestScore := b.nodes[0], math.Inf(-1)
+	for _, node := range b.nodes {
+		score := b.ucbScore(b.arms[node])
+		if score > bestScore {
+			best, bestScore = node, score
+		}
+	}
+	return best
+}
+
+// untried nodes score +Inf. A node that was picked, but hasn't completed any
+// request yet is optimistically assumed to be rewarded 1.
+func (b *Bandit) ucbScore(a *arm) float64 {
+	if a.pulls == 0 {
+		return math.Inf(1)
+	}
+
+	mean := 1.0
+	if a.trials != 0 {
+		mean = a.rewards / float64(a.trials)
+	}
+
+	bonus := math.Sqrt(math.Log(float64(b.pulls)) / float64(a.pulls))
+	return mean + b.Exploration*bonus
+}
+
+func (b *Bandit) sampleBest() QueueNode {
+	best, bestSample := b.nodes[0], math.Inf(-1)
+	for _, node := range b.nodes {
+		alpha, beta := b.posterior(b.arms[node])
+		sample := sampleBeta(b.rng, alpha, beta)
+
+		if sample > bestSample {
+			best, bestSample = node, sample
+		}
+	}
+	return best
+}
+
+// parameters of the Beta posterior of the node's mean reward.
+func (b *Bandit) posterior(a *arm) (float64, float64) {
+	return b.Prior[0] + a.rewards, b.Prior[1] + float64(a.trials) - a.rewards
+}
+
+// Beta(alpha, beta) from two Gamma samples.
+func sampleBeta(rng *rand.Rand, alpha, beta float64) float64 {
+	x := sampleGamma(rng, alpha)
+	y := sampleGamma(rng, beta)
+	if x+y == 0 {
+		return 0.5
+	}
+	return x / (x + y)
+}
+
+// Gamma(shape, 1), Marsaglia and Tsang's method. Shapes below 1 are boosted
+// to shape + 1, then scaled back by U^(1/shape).
+func sampleGamma(rng *rand.Rand, shape float64) float64 {
+	if shape < 1.0 {
+		return sampleGamma(rng, shape+1.0) *
+			math.Pow(rng.Float64(), 1.0/shape)
+	}
+
+	d := shape - 1.0/3.0
+	c := 1.0 / math.Sqrt(9.0*d)
+	for {
+		x := rng.NormFloat64()
+		v := 1.0 + c*x
+		if v <= 0 {
+			continue
+		}
+
+		v = v * v * v
+		u := rng.Float64()
+		if math.Log(u) < 0.5*x*x+d-d*v+d*math.Log(v) {
+			return d * v
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package algo
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func makeTestBandit(policy string, nodeCount int) (*Bandit, []*testLoaded) {
+	b := &Bandit{Policy: policy, rng: rand.New(rand.NewSource(1))}
+	b.Initialize()
+
+	nodes := make([]*testLoaded, nodeCount)
+	for i := range nodes {
+		nodes[i] = &testLoaded{testNodeID{nodeID: uint16(i)}, 0}
+		b.NodeJoin(nodes[i])
+	}
+
+	return b, nodes
+}
+
+func TestBanditUCB1(t *testing.T) {
+	b, nodes := makeTestBandit(BanditUCB1, 3)
+
+	// untried nodes are picked first
+	for _, expected := range nodes {
+		node, err := b.GetNode()
+		assert.Nil(t, err)
+		assert.Same(t, expected, node)
+	}
+
+	// node 1 is an order of magnitude faster than the others
+	for range 20 {
+		for i, node := range nodes {
+			latency := 500 * time.Millisecond
+			if i == 1 {
+				latency = 10 * time.Millisecond
+			}
+			b.Observe(node, nil, latency)
+		}
+	}
+
+	picks := make(map[QueueNode]int)
+	for range 100 {
+		node, err := b.GetNode()
+		assert.Nil(t, err)
+		picks[node]++
+	}
+	assert.Greater(t, picks[nodes[1]], 80)
+
+	// the slow nodes still get explored
+	assert.NotZero(t, picks[nodes[0]]+picks[nodes[2]])
+
+	// UCB1 is deterministic, the next pick has probability 1
+	states := b.Snapshot()
+	assert.Equal(t, 3, len(states))
+	assert.Equal(t, 1.0, states[0].Score)
+	assert.Equal(t, 0.0, states[1].Score+states[2].Score)
+
+	// the pulls of a node leaving are forgotten
+	b.NodeLeave(nodes[1])
+	assert.Equal(t, uint64(picks[nodes[0]]+picks[nodes[2]]+2), b.pulls)
+	node, err := b.GetNode()
+	assert.Nil(t, err)
+	assert.NotSame(t, nodes[1], node)
+}
+
+func TestBanditThompson(t *testing.T) {
+	b, nodes := makeTestBandit(BanditThompson, 3)
+
+	// no observation, every node is at the prior mean
+	states := b.Snapshot()
+	for _, state := range states {
+		assert.Equal(t, 0.5, state.Score)
+	}
+
+	for range 50 {
+		b.Observe(nodes[0], nil, 500*time.Millisecond)
+		b.Observe(nodes[1], nil, time.Millisecond)
+		b.Observe(nodes[2], nil, time.Second)
+	}
+
+	picks := make(map[QueueNode]int)
+	for range 100 {
+		node, err := b.GetNode()
+		assert.Nil(t, err)
+		picks[node]++
+	}
+	assert.Greater(t, picks[nodes[1]], 90)
+
+	// the fastest node has the highest posterior mean
+	states = b.Snapshot()
+	assert.Equal(t, uint16(1), states[0].ID)
+	assert.Equal(t, uint16(2), states[2].ID)
+	assert.Greater(t, states[0].Score, 0.9)
+}
+
+func TestBanditThompsonSnapshotReplicable(t *testing.T) {
+	// the same seed picks the same nodes, however many snapshots are taken
+	b, nodes := makeTestBandit(BanditThompson, 4)
+	other, otherNodes := makeTestBandit(BanditThompson, 4)
+
+	for i := range 100 {
+		for range i % 5 {
+			other.Snapshot()
+		}
+
+		node, err := b.GetNode()
+		assert.Nil(t, err)
+		otherNode, err := other.GetNode()
+		assert.Nil(t, err)
+		assert.Equal(t, node.ID(), otherNode.ID())
+
+		latency := time.Duration(node.ID()+1) * 10 * time.Millisecond
+		b.Observe(nodes[node.ID()], nil, latency)
+		other.Observe(otherNodes[node.ID()], nil, latency)
+	}
+}
+
+func TestBanditSampleBeta(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	// mean of Beta(a, b) is a / (a + b)
+	for _, params := range [][2]float64{{1, 1}, {2, 8}, {0.5, 0.5}, {30, 10}} {
+		sum := 0.0
+		for range 10000 {
+			x := sampleBeta(rng, params[0], params[1])
+			assert.True(t, 0 <= x && x <= 1)
+			sum += x
+		}
+		assert.InDelta(t, params[0]/(params[0]+params[1]), sum/10000, 0.02)
+	}
+}
+
+func TestBanditEmpty(t *testing.T) {
+	b, _ := makeTestBandit(BanditUCB1, 0)
+	_, err := b.GetNode()
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,99 @@
+package algo
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+func init() {
+	Register(AlgoBoundedLoadHash, func(opts Options) (LBAlgo, error) {
+		bl := &BoundedLoadHash{}
+		if err := opts.Decode(bl); err != nil {
+			return nil, err
+		}
+		return bl, bl.validate()
+	})
+}
+
+// BoundedLoadHash implements KeyedLBAlgo.
+//
+// Consistent hashing with bounded loads (Mirrokni et al.): a node may not
+// hold more than ceil(c * average load) active requests, where c is the
+// `LoadFactor`. When the node owning a key is at capacity, the key spills to
+// the next node on the ring that isn't.
+type BoundedLoadHash struct {
+	ConsistentHash `yaml:",inline"`
+	LoadFactor     float64 `yaml:"load-factor"`
+	nodes          []QueueNode
+}
+
+const defaultLoadFactor = 1.25
+
+func (bl *BoundedLoadHash) Initialize() {
+	if bl.LoadFactor == 0 {
+		bl.LoadFactor = defaultLoadFactor
+	}
+	bl.ConsistentHash.Initialize()
+	bl.nodes = make([]QueueNode, 0)
+}
+
+// with a load factor <= 1, every node can be at capacity at once.
+func (bl *BoundedLoadHash) validate() error {
+	if bl.LoadFactor != 0 && bl.LoadFactor <= 1.0 {
+		return fmt.Errorf("expected a load factor greater than 1, got %g.", bl.LoadFactor)
+	}
+	return nil
+}
+
+func (bl *BoundedLoadHash) NodeJoin(node QueueNode) {
+	bl.ConsistentHash.NodeJoin(node)
+	bl.nodes = append(bl.nodes, node)
+}
+
+func (bl *BoundedLoadHash) NodeLeave(node QueueNode) {
+	bl.ConsistentHash.NodeLeave(node)
+	bl.nodes, _ = removeNode(bl.nodes, node)
+}
+
+func (bl *BoundedLoadHash) GetNode() (QueueNode, error) {
+	if len(bl.ring) == 0 {
+		return nil, errors.New("no node can be scheduled.")
+	}
+	return bl.walk(bl.successor(bl.rng.Uint64())), nil
+}
+
+// KeyedLBAlgo implementation
+func (bl *BoundedLoadHash) GetNodeByKey(key []byte) (QueueNode, error) {
+	if len(bl.ring) == 0 {
+		return nil, errors.New("no node can be scheduled.")
+	}
+	return bl.walk(bl.successor(hashKey(key))), nil
+}
+
+// the max number of active requests a node may hold, counting the request
+// being scheduled.
+func (bl *BoundedLoadHash) capacity() uint64 {
+	var total uint64
+	for _, node := range bl.nodes {
+		total += node.ActiveRequests()
+	}
+
+	avg := float64(total+1) / float64(len(bl.nodes))
+	return uint64(math.Ceil(bl.LoadFactor * avg))
+}
+
+// walks the ring clockwise from the point `start`, returning the first node
+// that's under capacity.
+func (bl *BoundedLoadHash) walk(start int) QueueNode {
+	capacity := bl.capacity()
+	for i := range bl.ring {
+		node := bl.ring[(start+i)%len(bl.ring)].node
+		if node.ActiveRequests() < capacity {
+			return node
+		}
+	}
+
+	// unreachable with a load factor > 1, some node is always below average.
+	return bl.ring[start].node
+}
@@ -0,0 +1,71 @@
+package algo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testLoaded struct {
+	testNodeID
+	active uint64
+}
+
+// ActiveRequests implements QueueNode.
+func (t *testLoaded) ActiveRequests() uint64 {
+	return t.active
+}
+
+func TestBoundedLoadHashInitialize(t *testing.T) {
+	bl := BoundedLoadHash{}
+	bl.Initialize()
+	assert.Equal(t, defaultLoadFactor, bl.LoadFactor)
+	assert.Equal(t, defaultVirtualNodes, bl.VirtualNodes)
+}
+
+func TestBoundedLoadHashSpill(t *testing.T) {
+	const nodeCount = 4
+
+	bl := BoundedLoadHash{LoadFactor: 1.5}
+	bl.Initialize()
+
+	_, err := bl.GetNodeByKey([]byte("key"))
+	assert.NotNil(t, err)
+
+	nodes := make([]*testLoaded, nodeCount)
+	for i := range nodes {
+		nodes[i] = &testLoaded{testNodeID{nodeID: uint16(i)}, 0}
+		bl.NodeJoin(nodes[i])
+	}
+
+	// a single hot key, every request for it is kept in flight
+	key := []byte("hot file")
+	owner, err := bl.GetNodeByKey(key)
+	assert.Nil(t, err)
+
+	for range 100 {
+		node, err := bl.GetNodeByKey(key)
+		assert.Nil(t, err)
+		node.(*testLoaded).active++
+	}
+
+	// the owner is still the busiest, but no node goes over the bound
+	var total uint64
+	for _, node := range nodes {
+		total += node.active
+	}
+	assert.Equal(t, uint64(100), total)
+
+	for _, node := range nodes {
+		assert.LessOrEqual(t, float64(node.active), 1.5*100/nodeCount+1)
+		assert.LessOrEqual(t, node.active, owner.(*testLoaded).active)
+	}
+
+	// once the load drains, the key goes back to its owner
+	for _, node := range nodes {
+		node.active = 0
+	}
+	node, err := bl.GetNodeByKey(key)
+	assert.Nil(t, err)
+	assert.Same(t, owner, node)
+}
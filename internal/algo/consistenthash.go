@@ -0,0 +1,149 @@
+package algo
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+
+	"lukechampine.com/blake3"
+)
+
+func init() {
+	Register(AlgoConsistentHash, func(opts Options) (LBAlgo, error) {
+		ch := &ConsistentHash{}
+		return ch, opts.Decode(ch)
+	})
+}
+
+// ConsistentHash implements KeyedLBAlgo.
+//
+// Each node is placed on a hash ring `VirtualNodes` times, a key is served by
+// the first node found walking clockwise from the key's hash. Repeated
+// requests for the same key land on the same node, and a node joining or
+// leaving only moves the keys in the arcs it owns (about 1/n of them).
+type ConsistentHash struct {
+	VirtualNodes int `yaml:"virtual-nodes"`
+	ring         []ringPoint
+	rng          *rand.Rand
+}
+
+type ringPoint struct {
+	hash uint64
+	node QueueNode
+}
+
+const defaultVirtualNodes = 100
+
+func (ch *ConsistentHash) Initialize() {
+	if ch.VirtualNodes <= 0 {
+		ch.VirtualNodes = defaultVirtualNodes
+	}
+	ch.ring = make([]ringPoint, 0)
+	ch.rng = rand.New(rand.NewSource(rand.Int63()))
+}
+
+func (ch *ConsistentHash) NodeJoin(node QueueNode) {
+	key := nodeKey(node)
+	for i := range ch.VirtualNodes {
+		vnode := strconv.AppendInt(append(key, '#'), int64(i), 10)
+		ch.ring = append(ch.ring, ringPoint{hashKey(vnode), node})
+	}
+
+	sort.Slice(ch.ring, func(i, j int) bool {
+		return ch.ring[i].hash < ch.ring[j].hash
+	})
+}
+
+// only the keys in the arcs owned by the node move, to the next node on the
+// ring.
+func (ch *ConsistentHash) NodeLeave(node QueueNode) {
+	ring := ch.ring[:0]
+	for _, p := range ch.ring {
+		if p.node != node {
+			ring = append(ring, p)
+		}
+	}
+	ch.ring = ring
+}
+
+// keyless requests are spread over the ring at random.
+func (ch *ConsistentHash) GetNode() (QueueNode, error) {
+	if len(ch.ring) == 0 {
+		return nil, errors.New("no node can be scheduled.")
+	}
+	return ch.ring[ch.successor(ch.rng.Uint64())].node, nil
+}
+
+// KeyedLBAlgo implementation
+func (ch *ConsistentHash) GetNodeByKey(key []byte) (QueueNode, error) {
+	if len(ch.ring) == 0 {
+		return nil, errors.New("no node can be scheduled.")
+	}
+	return ch.ring[ch.successor(hashKey(key))].node, nil
+}
+
+func (ch *ConsistentHash) PutNode(node QueueNode) {
+	// nop
+}
+
+func (ch *ConsistentHash) Fix(int) error {
+	// nop
+	return nil
+}
+
+// returns the nodes in the order they first appear on the ring.
+func (ch *ConsistentHash) Queue() []QueueNode {
+	seen := make(map[QueueNode]bool)
+	q := make([]QueueNode, 0)
+	for _, p := range ch.ring {
+		if !seen[p.node] {
+			seen[p.node] = true
+			q = append(q, p.node)
+		}
+	}
+	return q
+}
+
+// ordered by first appearance on the ring, scored by the share of the ring
+// owned by the node.
+func (ch *ConsistentHash) Snapshot() []NodeState {
+	share := make(map[QueueNode]float64)
+	for i, p := range ch.ring {
+		// the point owns the arc from its predecessor, wrapping around
+		prev := ch.ring[(i+len(ch.ring)-1)%len(ch.ring)].hash
+		share[p.node] += float64(p.hash-prev) / math.MaxUint64
+	}
+
+	// a single point owns the whole ring
+	if len(ch.ring) == 1 {
+		share[ch.ring[0].node] = 1.0
+	}
+
+	return snapshot(ch.Queue(), nil, func(node QueueNode) float64 {
+		return share[node]
+	})
+}
+
+// index of the first point on the ring at or after `h`, wraps around.
+func (ch *ConsistentHash) successor(h uint64) int {
+	i := sort.Search(len(ch.ring), func(i int) bool {
+		return ch.ring[i].hash >= h
+	})
+	if i == len(ch.ring) {
+		i = 0
+	}
+	return i
+}
+
+func hashKey(key []byte) uint64 {
+	digest := blake3.Sum256(key)
+	return binary.LittleEndian.Uint64(digest[:8])
+}
+
+// the bytes identifying `node` for hashing purposes.
+func nodeKey(node QueueNode) []byte {
+	return binary.LittleEndian.AppendUint16(nil, node.ID())
+}
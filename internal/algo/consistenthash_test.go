@@ -0,0 +1,100 @@
+package algo
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testNodeID struct {
+	testStruct
+	nodeID uint16
+}
+
+// ID implements QueueNode.
+func (t *testNodeID) ID() uint16 {
+	return t.nodeID
+}
+
+func makeTestKeys(t *testing.T, n int) [][]byte {
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = make([]byte, 32)
+		_, err := rand.Read(keys[i])
+		assert.Nil(t, err)
+	}
+	return keys
+}
+
+func TestConsistentHashGetNodeByKey(t *testing.T) {
+	ch := ConsistentHash{}
+	ch.Initialize()
+	assert.Equal(t, defaultVirtualNodes, ch.VirtualNodes)
+
+	_, err := ch.GetNodeByKey([]byte("key"))
+	assert.NotNil(t, err)
+
+	for i := range 10 {
+		ch.NodeJoin(&testNodeID{nodeID: uint16(i)})
+	}
+	assert.Equal(t, 10*defaultVirtualNodes, len(ch.ring))
+	assert.Equal(t, 10, len(ch.Queue()))
+
+	// same key, same node
+	for _, key := range makeTestKeys(t, 100) {
+		first, err := ch.GetNodeByKey(key)
+		assert.Nil(t, err)
+
+		for range 5 {
+			node, err := ch.GetNodeByKey(key)
+			assert.Nil(t, err)
+			assert.Same(t, first, node)
+		}
+	}
+}
+
+func TestConsistentHashNodeJoinRemap(t *testing.T) {
+	const (
+		nodeCount = 10
+		keyCount  = 10000
+	)
+
+	ch := ConsistentHash{}
+	ch.Initialize()
+
+	for i := range nodeCount {
+		ch.NodeJoin(&testNodeID{nodeID: uint16(i)})
+	}
+
+	keys := makeTestKeys(t, keyCount)
+	before := make([]QueueNode, keyCount)
+	for i, key := range keys {
+		before[i], _ = ch.GetNodeByKey(key)
+	}
+
+	newNode := &testNodeID{nodeID: nodeCount}
+	ch.NodeJoin(newNode)
+
+	moved := 0
+	for i, key := range keys {
+		node, _ := ch.GetNodeByKey(key)
+		if node != before[i] {
+			// keys only ever move to the new node
+			assert.Same(t, newNode, node)
+			moved++
+		}
+	}
+
+	// expecting about 1/(n+1) of the keys to move
+	expected := keyCount / (nodeCount + 1)
+	assert.InDelta(t, expected, moved, float64(expected)/2)
+
+	// the node leaves, every key goes back to where it was
+	ch.NodeLeave(newNode)
+	assert.Equal(t, nodeCount*defaultVirtualNodes, len(ch.ring))
+	for i, key := range keys {
+		node, _ := ch.GetNodeByKey(key)
+		assert.Same(t, before[i], node)
+	}
+}
@@ -0,0 +1,129 @@
+package algo
+
+import (
+	"encoding/hex"
+	"errors"
+)
+
+func init() {
+	Register(AlgoLeastBytes, func(opts Options) (LBAlgo, error) {
+		sizes, err := opts.Catalog()
+		return &LeastOutstandingBytes{Sizes: sizes}, err
+	})
+}
+
+// LeastOutstandingBytes implements KeyedLBAlgo, Completer and Assigner.
+//
+// Tracks the number of bytes each node has yet to serve, the request goes to
+// the node with the least outstanding bytes. File sizes are looked up in
+// `Sizes`, keyed by the hex encoded digest. The bytes are added when a node
+// is picked, and removed when the node reports the request as done.
+type LeastOutstandingBytes struct {
+	Sizes       map[string]uint64 `yaml:"-"`
+	nodes       []QueueNode
+	outstanding map[QueueNode]uint64
+}
+
+// size of a request for a file not found in the catalog.
+const unknownFileSize = 1
+
+func (lb *LeastOutstandingBytes) Initialize() {
+	if lb.Sizes == nil {
+		lb.Sizes = make(map[string]uint64)
+	}
+	lb.nodes = make([]QueueNode, 0)
+	lb.outstanding = make(map[QueueNode]uint64)
+}
+
+func (lb *LeastOutstandingBytes) NodeJoin(node QueueNode) {
+	lb.nodes = append(lb.nodes, node)
+	lb.outstanding[node] = 0
+}
+
+func (lb *LeastOutstandingBytes) NodeLeave(node QueueNode) {
+	lb.nodes, _ = removeNode(lb.nodes, node)
+	delete(lb.outstanding, node)
+}
+
+func (lb *LeastOutstandingBytes) GetNode() (QueueNode, error) {
+	if len(lb.nodes) == 0 {
+		return nil, errors.New("no node can be scheduled.")
+	}
+
+	// ties are broken on the active requests
+	best := lb.nodes[0]
+	for _, node := range lb.nodes[1:] {
+		nodeBytes, bestBytes := lb.outstanding[node], lb.outstanding[best]
+		if nodeBytes < bestBytes ||
+			(nodeBytes == bestBytes && lessConnections(node, best)) {
+			best = node
+		}
+	}
+
+	return best, nil
+}
+
+// KeyedLBAlgo implementation
+func (lb *LeastOutstandingBytes) GetNodeByKey(key []byte) (QueueNode, error) {
+	node, err := lb.GetNode()
+	if err != nil {
+		return nil, err
+	}
+
+	lb.Assign(node, key)
+	return node, nil
+}
+
+// Assigner implementation
+func (lb *LeastOutstandingBytes) Assign(node QueueNode, key []byte) {
+	if _, ok := lb.outstanding[node]; ok {
+		lb.outstanding[node] += lb.size(key)
+	}
+}
+
+func (lb *LeastOutstandingBytes) PutNode(node QueueNode) {
+	// nop
+}
+
+func (lb *LeastOutstandingBytes) Fix(int) error {
+	// nop
+	return nil
+}
+
+func (lb *LeastOutstandingBytes) Queue() []QueueNode {
+	return lb.nodes
+}
+
+// scored by the outstanding bytes, ties are broken on the active requests.
+func (lb *LeastOutstandingBytes) Snapshot() []NodeState {
+	less := func(left, right QueueNode) bool {
+		l, r := lb.outstanding[left], lb.outstanding[right]
+		return l < r || (l == r && lessConnections(left, right))
+	}
+
+	return snapshot(lb.nodes, less, func(node QueueNode) float64 {
+		return float64(lb.outstanding[node])
+	})
+}
+
+// Completer implementation
+func (lb *LeastOutstandingBytes) Complete(node QueueNode, key []byte) {
+	outstanding, ok := lb.outstanding[node]
+	if !ok {
+		return
+	}
+
+	size := lb.size(key)
+	if size > outstanding {
+		size = outstanding
+	}
+	lb.outstanding[node] = outstanding - size
+}
+
+func (lb *LeastOutstandingBytes) size(key []byte) uint64 {
+	size, ok := lb.Sizes[hex.EncodeToString(key)]
+	if !ok {
+		return unknownFileSize
+	}
+	return size
+}
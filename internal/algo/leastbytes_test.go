@@ -0,0 +1,58 @@
+package algo
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeastOutstandingBytes(t *testing.T) {
+	var (
+		small = []byte{0x01}
+		large = []byte{0x02}
+	)
+
+	lb := LeastOutstandingBytes{
+		Sizes: map[string]uint64{
+			hex.EncodeToString(small): 1 << 8,
+			hex.EncodeToString(large): 1 << 30,
+		},
+	}
+	lb.Initialize()
+
+	_, err := lb.GetNodeByKey(small)
+	assert.NotNil(t, err)
+
+	nodes := []*testLoaded{{}, {}, {}}
+	for _, node := range nodes {
+		lb.NodeJoin(node)
+	}
+
+	// a large file, then small ones never queue behind it
+	node, err := lb.GetNodeByKey(large)
+	assert.Nil(t, err)
+	assert.Same(t, nodes[0], node)
+	assert.Equal(t, uint64(1<<30), lb.outstanding[nodes[0]])
+
+	for range 100 {
+		node, err := lb.GetNodeByKey(small)
+		assert.Nil(t, err)
+		assert.NotSame(t, nodes[0], node)
+	}
+	assert.Equal(t, uint64(50<<8), lb.outstanding[nodes[1]])
+	assert.Equal(t, uint64(50<<8), lb.outstanding[nodes[2]])
+
+	// the large file is done
+	lb.Complete(nodes[0], large)
+	assert.Equal(t, uint64(0), lb.outstanding[nodes[0]])
+
+	node, err = lb.GetNodeByKey(small)
+	assert.Nil(t, err)
+	assert.Same(t, nodes[0], node)
+
+	// unknown files count as 1 byte, and never underflow
+	lb.Complete(nodes[0], []byte{0xff})
+	lb.Complete(nodes[0], large)
+	assert.Equal(t, uint64(0), lb.outstanding[nodes[0]])
+}
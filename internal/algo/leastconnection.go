@@ -5,12 +5,19 @@ import (
 	"errors"
 )
 
+func init() {
+	Register(AlgoLeastConnections, func(Options) (LBAlgo, error) {
+		return &LeastConnection{}, nil
+	})
+}
+
 type LeastConnection struct {
-	priorityQueue
+	heapQueue
 }
 
 // LeastConnection implements LBAlgo
 func (lc *LeastConnection) Initialize() {
+	lc.less = lessConnections
 	heap.Init(lc)
 }
 
@@ -19,6 +26,11 @@ func (lc *LeastConnection) NodeJoin(node QueueNode) {
 	heap.Push(lc, node)
 }
 
+// LeastConnection implements LBAlgo
+func (lc *LeastConnection) NodeLeave(node QueueNode) {
+	lc.remove(node)
+}
+
 // LeastConnection implements LBAlgo
 func (lc *LeastConnection) GetNode() (QueueNode, error) {
 	if lc.Len() == 0 {
@@ -35,11 +47,11 @@ func (lc *LeastConnection) PutNode(node QueueNode) {
 
 // LeastConnection implements LBAlgo
 func (lc *LeastConnection) Fix(i int) error {
-	if i >= lc.priorityQueue.Len() {
+	if i < 0 || i >= lc.priorityQueue.Len() {
 		return errors.New("index i out of bound.")
 	}
 
-	heap.Fix(&lc.priorityQueue, i)
+	heap.Fix(lc, i)
 	return nil
 }
 
@@ -47,3 +59,13 @@ func (lc *LeastConnection) Fix(i int) error {
 func (lc *LeastConnection) Queue() []QueueNode {
 	return lc.priorityQueue
 }
+
+// LeastConnection implements LBAlgo, scored by the active requests.
+func (lc *LeastConnection) Snapshot() []NodeState {
+	return snapshot(lc.priorityQueue, lessConnections, activeScore)
+}
+
+// the node with the fewest active requests comes first.
+func lessConnections(left, right QueueNode) bool {
+	return left.ActiveRequests() < right.ActiveRequests()
+}
@@ -0,0 +1,92 @@
+package algo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testStats struct {
+	testLoaded
+	avgRT float64
+}
+
+// AvgResponseTime implements QueueNode.
+func (t *testStats) AvgResponseTime() float64 {
+	return t.avgRT
+}
+
+func TestLeastConnectionSnapshot(t *testing.T) {
+	lc := LeastConnection{}
+	lc.Initialize()
+
+	for i, active := range []uint64{5, 1, 3, 0} {
+		lc.NodeJoin(&testLoaded{testNodeID{nodeID: uint16(i)}, active})
+	}
+
+	states := lc.Snapshot()
+	for i, id := range []uint16{3, 1, 2, 0} {
+		assert.Equal(t, id, states[i].ID)
+		assert.Equal(t, i, states[i].Position)
+		assert.Equal(t, float64(states[i].Active), states[i].Score)
+	}
+
+	// the snapshot is a copy, the heap is untouched
+	node, err := lc.GetNode()
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(3), node.ID())
+	assert.Equal(t, 4, len(states))
+}
+
+func TestLeastResponseTimeSnapshot(t *testing.T) {
+	lrt := LeastResponseTime{}
+	lrt.Initialize()
+
+	nodes := []*testStats{
+		{testLoaded{testNodeID{nodeID: 0}, 1}, 10.0},
+		{testLoaded{testNodeID{nodeID: 1}, 0}, 30.0},
+		{testLoaded{testNodeID{nodeID: 2}, 0}, 20.0},
+	}
+	for _, node := range nodes {
+		lrt.NodeJoin(node)
+	}
+
+	states := lrt.Snapshot()
+	for i, id := range []uint16{2, 1, 0} {
+		assert.Equal(t, id, states[i].ID)
+		assert.Equal(t, nodes[id].avgRT, states[i].Score)
+	}
+}
+
+func TestLeastConnectionNodeLeave(t *testing.T) {
+	for _, lbAlgo := range []LBAlgo{&LeastConnection{}, &LeastResponseTime{}} {
+		lbAlgo.Initialize()
+
+		nodes := make([]*testLoaded, 8)
+		for i := range nodes {
+			nodes[i] = &testLoaded{active: uint64(i)}
+			lbAlgo.NodeJoin(nodes[i])
+		}
+
+		// remove the least loaded node, and one in the middle of the heap
+		lbAlgo.NodeLeave(nodes[0])
+		lbAlgo.NodeLeave(nodes[5])
+		assert.Equal(t, 6, len(lbAlgo.Queue()))
+		assert.Equal(t, -1, nodes[0].Index())
+
+		// indexes are kept in sync
+		for i, node := range lbAlgo.Queue() {
+			assert.Equal(t, i, node.Index())
+		}
+
+		// removing twice is a nop
+		lbAlgo.NodeLeave(nodes[5])
+		assert.Equal(t, 6, len(lbAlgo.Queue()))
+
+		for _, expected := range []int{1, 2, 3, 4, 6, 7} {
+			node, err := lbAlgo.GetNode()
+			assert.Nil(t, err)
+			assert.Same(t, nodes[expected], node)
+		}
+	}
+}
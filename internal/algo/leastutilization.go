@@ -0,0 +1,98 @@
+package algo
+
+import (
+	"container/heap"
+	"errors"
+)
+
+func init() {
+	Register(AlgoLeastUtilization, func(Options) (LBAlgo, error) {
+		return &LeastUtilization{}, nil
+	})
+}
+
+// LeastUtilization implements LBAlgo.
+//
+// Capacity aware least connections: the request goes to the node with the
+// lowest utilization (active requests / capacity) once the request is
+// placed, so nodes with more workers get proportionally more requests.
+// Nodes that don't advertise a capacity are considered to have 1 worker.
+type LeastUtilization struct {
+	heapQueue
+}
+
+// LeastUtilization implements LBAlgo
+func (lu *LeastUtilization) Initialize() {
+	lu.less = lessUtilization
+	heap.Init(lu)
+}
+
+// LeastUtilization implements LBAlgo
+func (lu *LeastUtilization) NodeJoin(node QueueNode) {
+	heap.Push(lu, node)
+}
+
+// LeastUtilization implements LBAlgo
+func (lu *LeastUtilization) NodeLeave(node QueueNode) {
+	lu.remove(node)
+}
+
+// LeastUtilization implements LBAlgo
+func (lu *LeastUtilization) GetNode() (QueueNode, error) {
+	if lu.Len() == 0 {
+		return nil, errors.New("queue empty")
+	}
+	node := heap.Pop(lu).(QueueNode)
+	return node, nil
+}
+
+// LeastUtilization implements LBAlgo
+func (lu *LeastUtilization) PutNode(node QueueNode) {
+	lu.NodeJoin(node)
+}
+
+// LeastUtilization implements LBAlgo
+func (lu *LeastUtilization) Fix(i int) error {
+	if i < 0 || i >= lu.priorityQueue.Len() {
+		return errors.New("index i out of bound.")
+	}
+
+	heap.Fix(lu, i)
+	return nil
+}
+
+// LeastUtilization implements LBAlgo
+func (lu *LeastUtilization) Queue() []QueueNode {
+	return lu.priorityQueue
+}
+
+// LeastUtilization implements LBAlgo, scored by the current utilization.
+func (lu *LeastUtilization) Snapshot() []NodeState {
+	return snapshot(lu.priorityQueue, lessUtilization, func(node QueueNode) float64 {
+		return float64(node.ActiveRequests()) / float64(nodeCapacity(node))
+	})
+}
+
+// the node with the lowest utilization after placing a request comes first,
+// ties go to the node with the larger capacity.
+func lessUtilization(left, right QueueNode) bool {
+	lcap, rcap := uint64(nodeCapacity(left)), uint64(nodeCapacity(right))
+
+	// (l+1)/lcap < (r+1)/rcap
+	l := (left.ActiveRequests() + 1) * rcap
+	r := (right.ActiveRequests() + 1) * lcap
+	if l == r {
+		return lcap > rcap
+	}
+	return l < r
+}
+
+// returns the advertised capacity of the node, nodes that don't advertise one
+// (or advertise 0) have a capacity of 1.
+func nodeCapacity(node QueueNode) uint16 {
+	c, ok := node.(Capacitated)
+	if !ok || c.Capacity() == 0 {
+		return 1
+	}
+	return c.Capacity()
+}
@@ -0,0 +1,67 @@
+package algo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCapacitated struct {
+	testLoaded
+	capacity uint16
+}
+
+// Capacity implements Capacitated.
+func (t *testCapacitated) Capacity() uint16 {
+	return t.capacity
+}
+
+func TestLeastUtilizationGetNode(t *testing.T) {
+	lu := LeastUtilization{}
+	lu.Initialize()
+
+	_, err := lu.GetNode()
+	assert.NotNil(t, err)
+
+	small := &testCapacitated{capacity: 10}
+	large := &testCapacitated{capacity: 30}
+	lu.NodeJoin(small)
+	lu.NodeJoin(large)
+
+	// requests are split by capacity
+	counter := make(map[QueueNode]int)
+	for range 40 {
+		node, err := lu.GetNode()
+		assert.Nil(t, err)
+
+		node.(*testCapacitated).active++
+		counter[node]++
+		lu.PutNode(node)
+	}
+
+	assert.Equal(t, 10, counter[small])
+	assert.Equal(t, 30, counter[large])
+}
+
+func TestLeastUtilizationIdleNode(t *testing.T) {
+	lu := LeastUtilization{}
+	lu.Initialize()
+
+	// 9/10 busy, vs 0/2
+	busy := &testCapacitated{testLoaded{active: 9}, 10}
+	idle := &testCapacitated{testLoaded{active: 0}, 2}
+	lu.NodeJoin(busy)
+	lu.NodeJoin(idle)
+
+	node, err := lu.GetNode()
+	assert.Nil(t, err)
+	assert.Same(t, idle, node)
+	lu.PutNode(node)
+
+	// the capacity defaults to 1
+	assert.Equal(t, uint16(1), nodeCapacity(&testLoaded{}))
+
+	states := lu.Snapshot()
+	assert.Equal(t, 0.0, states[0].Score)
+	assert.Equal(t, 0.9, states[1].Score)
+}
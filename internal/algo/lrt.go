@@ -5,12 +5,19 @@ import (
 	"errors"
 )
 
+func init() {
+	Register(AlgoLeastResponseTime, func(Options) (LBAlgo, error) {
+		return &LeastResponseTime{}, nil
+	})
+}
+
 type LeastResponseTime struct {
-	priorityQueue
+	heapQueue
 }
 
 // LeastResponseTime implements LBAlgo
 func (lrt *LeastResponseTime) Initialize() {
+	lrt.less = lessResponseTime
 	heap.Init(lrt)
 }
 
@@ -19,6 +26,11 @@ func (lrt *LeastResponseTime) NodeJoin(node QueueNode) {
 	heap.Push(lrt, node)
 }
 
+// LeastResponseTime implements LBAlgo
+func (lrt *LeastResponseTime) NodeLeave(node QueueNode) {
+	lrt.remove(node)
+}
+
 // LeastResponseTime implements LBAlgo
 func (lrt *LeastResponseTime) GetNode() (QueueNode, error) {
 	if lrt.Len() == 0 {
@@ -35,11 +47,11 @@ func (lrt *LeastResponseTime) PutNode(node QueueNode) {
 
 // LeastResponseTime implements LBAlgo
 func (lrt *LeastResponseTime) Fix(i int) error {
-	if i >= lrt.priorityQueue.Len() {
+	if i < 0 || i >= lrt.priorityQueue.Len() {
 		return errors.New("index i out of bound.")
 	}
 
-	heap.Fix(&lrt.priorityQueue, i)
+	heap.Fix(lrt, i)
 	return nil
 }
 
@@ -47,3 +59,19 @@ func (lrt *LeastResponseTime) Fix(i int) error {
 func (lrt *LeastResponseTime) Queue() []QueueNode {
 	return lrt.priorityQueue
 }
+
+// LeastResponseTime implements LBAlgo, scored by the average response time.
+func (lrt *LeastResponseTime) Snapshot() []NodeState {
+	return snapshot(lrt.priorityQueue, lessResponseTime, func(node QueueNode) float64 {
+		return node.AvgResponseTime()
+	})
+}
+
+// the node with the fewest active requests comes first, ties are broken on the
+// average response time.
+func lessResponseTime(left, right QueueNode) bool {
+	if left.ActiveRequests() == right.ActiveRequests() {
+		return left.AvgResponseTime() < right.AvgResponseTime()
+	}
+	return left.ActiveRequests() < right.ActiveRequests()
+}
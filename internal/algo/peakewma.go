@@ -0,0 +1,148 @@
+package algo
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+func init() {
+	Register(AlgoPeakEWMA, func(opts Options) (LBAlgo, error) {
+		pe := &PeakEWMA{}
+		return pe, opts.Decode(pe)
+	})
+}
+
+// PeakEWMA implements LBAlgo and Observer.
+//
+// Latency-aware balancing as done in Finagle: every node keeps an
+// exponentially weighted moving average of its response times, decayed over
+// time with the `DecayWindow` as time constant. A latency above the average
+// replaces it right away (the "peak"), so a degrading node is penalized
+// immediately and only recovers gradually. A node's cost is its average
+// latency multiplied by its number of active requests + 1, the cheapest node
+// is picked.
+type PeakEWMA struct {
+	DecayWindow time.Duration `yaml:"decay-window"`
+	nodes       []QueueNode
+	stats       map[QueueNode]*ewma
+
+	now func() time.Time
+}
+
+type ewma struct {
+	cost  float64   // in nanoseconds
+	stamp time.Time // last time the cost was updated
+}
+
+const (
+	defaultDecayWindow = 10 * time.Second
+
+	// cost of a node that is busy, but hasn't reported any latency yet, so
+	// untested nodes don't get piled on.
+	ewmaPenalty = float64(math.MaxInt64 >> 16)
+)
+
+func (pe *PeakEWMA) Initialize() {
+	if pe.DecayWindow <= 0 {
+		pe.DecayWindow = defaultDecayWindow
+	}
+	if pe.now == nil {
+		pe.now = time.Now
+	}
+	pe.nodes = make([]QueueNode, 0)
+	pe.stats = make(map[QueueNode]*ewma)
+}
+
+func (pe *PeakEWMA) NodeJoin(node QueueNode) {
+	pe.nodes = append(pe.nodes, node)
+	pe.stats[node] = &ewma{0.0, pe.now()}
+}
+
+func (pe *PeakEWMA) NodeLeave(node QueueNode) {
+	pe.nodes, _ = removeNode(pe.nodes, node)
+	delete(pe.stats, node)
+}
+
+func (pe *PeakEWMA) GetNode() (QueueNode, error) {
+	if len(pe.nodes) == 0 {
+		return nil, errors.New("no node can be scheduled.")
+	}
+
+	now := pe.now()
+	best, bestCost := pe.nodes[0], math.Inf(1)
+
+	for _, node := range pe.nodes {
+		cost := pe.cost(node, now)
+		if cost < bestCost {
+			best, bestCost = node, cost
+		}
+	}
+
+	return best, nil
+}
+
+func (pe *PeakEWMA) PutNode(node QueueNode) {
+	// nop
+}
+
+func (pe *PeakEWMA) Fix(int) error {
+	// nop
+	return nil
+}
+
+func (pe *PeakEWMA) Queue() []QueueNode {
+	return pe.nodes
+}
+
+// scored by the cost, the cheapest node is picked next.
+func (pe *PeakEWMA) Snapshot() []NodeState {
+	now := pe.now()
+	costs := make(map[QueueNode]float64, len(pe.nodes))
+	for _, node := range pe.nodes {
+		costs[node] = pe.cost(node, now)
+	}
+
+	less := func(left, right QueueNode) bool {
+		return costs[left] < costs[right]
+	}
+
+	return snapshot(pe.nodes, less, func(node QueueNode) float64 {
+		return costs[node]
+	})
+}
+
+// Observer implementation
+func (pe *PeakEWMA) Observe(node QueueNode, _ []byte, latency time.Duration) {
+	stat, ok := pe.stats[node]
+	if !ok {
+		return
+	}
+	stat.update(float64(latency.Nanoseconds()), pe.now(), pe.DecayWindow)
+}
+
+// the decayed latency, multiplied by the active requests + 1.
+func (pe *PeakEWMA) cost(node QueueNode, now time.Time) float64 {
+	stat := pe.stats[node]
+	stat.update(0.0, now, pe.DecayWindow)
+
+	pending := float64(node.ActiveRequests())
+	if stat.cost == 0.0 && pending != 0.0 {
+		return ewmaPenalty + pending
+	}
+
+	return stat.cost * (pending + 1)
+}
+
+func (e *ewma) update(latency float64, now time.Time, window time.Duration) {
+	elapsed := max(now.Sub(e.stamp), 0)
+	e.stamp = now
+
+	if latency > e.cost {
+		e.cost = latency
+		return
+	}
+
+	w := math.Exp(-float64(elapsed) / float64(window))
+	e.cost = e.cost*w + latency*(1.0-w)
+}
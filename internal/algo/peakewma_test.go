@@ -0,0 +1,90 @@
+package algo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testClock struct {
+	t time.Time
+}
+
+func (c *testClock) now() time.Time {
+	return c.t
+}
+
+func makeTestPeakEWMA(clock *testClock, nodeCount int) (*PeakEWMA, []*testLoaded) {
+	pe := &PeakEWMA{DecayWindow: time.Second, now: clock.now}
+	pe.Initialize()
+
+	nodes := make([]*testLoaded, nodeCount)
+	for i := range nodes {
+		nodes[i] = &testLoaded{testNodeID{nodeID: uint16(i)}, 0}
+		pe.NodeJoin(nodes[i])
+	}
+
+	return pe, nodes
+}
+
+func TestPeakEWMAGetNode(t *testing.T) {
+	clock := &testClock{time.Unix(0, 0)}
+	pe, nodes := makeTestPeakEWMA(clock, 3)
+
+	// untested nodes are tried first
+	for _, expected := range nodes {
+		node, err := pe.GetNode()
+		assert.Nil(t, err)
+		assert.Same(t, expected, node)
+		node.(*testLoaded).active++
+	}
+
+	pe.Observe(nodes[0], nil, 100*time.Millisecond)
+	pe.Observe(nodes[1], nil, 10*time.Millisecond)
+	pe.Observe(nodes[2], nil, 50*time.Millisecond)
+	for _, node := range nodes {
+		node.active = 0
+	}
+
+	node, err := pe.GetNode()
+	assert.Nil(t, err)
+	assert.Same(t, nodes[1], node)
+
+	// cost scales with the active requests
+	nodes[1].active = 5
+	node, err = pe.GetNode()
+	assert.Nil(t, err)
+	assert.Same(t, nodes[2], node)
+
+	// 10ms * 6 < 100ms
+	pe.NodeLeave(nodes[2])
+	assert.Equal(t, 2, len(pe.stats))
+	node, err = pe.GetNode()
+	assert.Nil(t, err)
+	assert.Same(t, nodes[1], node)
+}
+
+func TestPeakEWMADecay(t *testing.T) {
+	clock := &testClock{time.Unix(0, 0)}
+	pe, nodes := makeTestPeakEWMA(clock, 1)
+	stat := pe.stats[nodes[0]]
+
+	// a peak is taken right away
+	pe.Observe(nodes[0], nil, time.Second)
+	assert.Equal(t, float64(time.Second), stat.cost)
+
+	// a lower latency only pulls the average down gradually
+	clock.t = clock.t.Add(time.Second)
+	pe.Observe(nodes[0], nil, 0)
+	assert.InDelta(t, 0.3679*float64(time.Second), stat.cost, 1e5)
+	assert.Less(t, stat.cost, float64(time.Second))
+
+	// a degraded node jumps right back up
+	pe.Observe(nodes[0], nil, 2*time.Second)
+	assert.Equal(t, float64(2*time.Second), stat.cost)
+
+	// and decays over time when idle
+	clock.t = clock.t.Add(10 * time.Second)
+	assert.Less(t, pe.cost(nodes[0], clock.t), float64(time.Millisecond))
+}
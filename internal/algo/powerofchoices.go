@@ -0,0 +1,84 @@
+package algo
+
+import (
+	"errors"
+	"math/rand"
+)
+
+func init() {
+	Register(AlgoPowerOfChoices, func(opts Options) (LBAlgo, error) {
+		pc := &PowerOfChoices{}
+		return pc, opts.Decode(pc)
+	})
+}
+
+// PowerOfChoices implements LBAlgo.
+//
+// Samples `Choices` distinct nodes uniformly at random and picks the one with
+// the fewest active requests among them. With 2 choices this is the classic
+// "power of two choices". Nodes aren't kept in any order, so there's nothing
+// to fix up when a node's load changes.
+type PowerOfChoices struct {
+	Choices int `yaml:"choices"`
+	nodes   []QueueNode
+	rng     *rand.Rand
+}
+
+const defaultChoices = 2
+
+func (pc *PowerOfChoices) Initialize() {
+	if pc.Choices <= 0 {
+		pc.Choices = defaultChoices
+	}
+	pc.nodes = make([]QueueNode, 0)
+	pc.rng = rand.New(rand.NewSource(rand.Int63()))
+}
+
+func (pc *PowerOfChoices) NodeJoin(node QueueNode) {
+	pc.nodes = append(pc.nodes, node)
+}
+
+func (pc *PowerOfChoices) NodeLeave(node QueueNode) {
+	pc.nodes, _ = removeNode(pc.nodes, node)
+}
+
+func (pc *PowerOfChoices) GetNode() (QueueNode, error) {
+	n := len(pc.nodes)
+	if n == 0 {
+		return nil, errors.New("no node can be scheduled.")
+	}
+
+	d := min(pc.Choices, n)
+
+	// partial Fisher-Yates, the first d nodes are the sampled ones
+	var best QueueNode
+	for i := range d {
+		j := i + pc.rng.Intn(n-i)
+		pc.nodes[i], pc.nodes[j] = pc.nodes[j], pc.nodes[i]
+
+		if best == nil || lessConnections(pc.nodes[i], best) {
+			best = pc.nodes[i]
+		}
+	}
+
+	return best, nil
+}
+
+func (pc *PowerOfChoices) PutNode(node QueueNode) {
+	// nop
+}
+
+func (pc *PowerOfChoices) Fix(int) error {
+	// nop
+	return nil
+}
+
+func (pc *PowerOfChoices) Queue() []QueueNode {
+	return pc.nodes
+}
+
+// scored by the active requests, the sampling is random so the first node is
+// only the most likely to be picked.
+func (pc *PowerOfChoices) Snapshot() []NodeState {
+	return snapshot(pc.nodes, lessConnections, activeScore)
+}
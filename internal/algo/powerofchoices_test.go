@@ -0,0 +1,61 @@
+package algo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPowerOfChoicesInitialize(t *testing.T) {
+	pc := PowerOfChoices{}
+	pc.Initialize()
+	assert.Equal(t, defaultChoices, pc.Choices)
+
+	pc = PowerOfChoices{Choices: 3}
+	pc.Initialize()
+	assert.Equal(t, 3, pc.Choices)
+}
+
+func TestPowerOfChoicesGetNode(t *testing.T) {
+	pc := PowerOfChoices{Choices: 2}
+	pc.Initialize()
+
+	_, err := pc.GetNode()
+	assert.NotNil(t, err)
+
+	// with a single node, it's always picked
+	only := &testLoaded{active: 1}
+	pc.NodeJoin(only)
+	node, err := pc.GetNode()
+	assert.Nil(t, err)
+	assert.Same(t, only, node)
+
+	// the most loaded node is never picked with 2 choices
+	worst := &testLoaded{active: 100}
+	pc.NodeJoin(worst)
+	pc.NodeJoin(&testLoaded{active: 2})
+	pc.NodeJoin(&testLoaded{active: 3})
+
+	for range 100 {
+		node, err := pc.GetNode()
+		assert.Nil(t, err)
+		assert.NotSame(t, worst, node)
+	}
+}
+
+func TestPowerOfChoicesAllNodes(t *testing.T) {
+	// sampling every node degrades to picking the global minimum
+	pc := PowerOfChoices{Choices: 10}
+	pc.Initialize()
+
+	best := &testLoaded{active: 0}
+	pc.NodeJoin(&testLoaded{active: 1})
+	pc.NodeJoin(best)
+	pc.NodeJoin(&testLoaded{active: 2})
+
+	for range 10 {
+		node, err := pc.GetNode()
+		assert.Nil(t, err)
+		assert.Same(t, best, node)
+	}
+}
@@ -0,0 +1,77 @@
+package algo
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Factory makes a new algorithm from the options.
+type Factory func(opts Options) (LBAlgo, error)
+
+// Options are passed to the algorithm factories.
+type Options struct {
+	// decodes the algorithm specific options (the algorithm's entry in the
+	// `options` section of the load balancer config) into a typed struct, nil
+	// if there's none. Unknown options are an error.
+	Decode func(v any) error
+
+	// returns the size of each file, keyed by the hex encoded digest. Only
+	// called by the algorithms that need it.
+	Catalog func() (map[string]uint64, error)
+}
+
+var registry = make(map[string]Factory)
+
+// Register makes the algorithm available under `name`, it panics if the name
+// is already taken. Algorithms register themselves in their `init`.
+func Register(name string, factory Factory) {
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("algorithm registered twice [%s].", name))
+	}
+	registry[name] = factory
+}
+
+// New makes and initializes the algorithm registered under `name`.
+func New(name string, opts Options) (LBAlgo, error) {
+	factory, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported algorithm [%s].", name)
+	}
+
+	// options given to an algorithm that takes none are an error as well
+	decoded, decode := opts.Decode == nil, opts.Decode
+	opts.Decode = func(v any) error {
+		if decode == nil {
+			return nil
+		}
+		decoded = true
+		return decode(v)
+	}
+
+	if opts.Catalog == nil {
+		opts.Catalog = func() (map[string]uint64, error) {
+			return nil, fmt.Errorf("no file catalog for algorithm [%s].", name)
+		}
+	}
+
+	algo, err := factory(opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid options for algorithm [%s]: %w", name, err)
+	}
+	if !decoded {
+		return nil, fmt.Errorf("algorithm [%s] takes no options.", name)
+	}
+
+	algo.Initialize()
+	return algo, nil
+}
+
+// Registered returns the names of all registered algorithms, sorted.
+func Registered() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,152 @@
+package algo
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestRegistryRegistered(t *testing.T) {
+	names := Registered()
+	for _, name := range []string{
+		AlgoSimpleRoundRobin, AlgoLeastResponseTime, AlgoLeastConnections,
+		AlgoWeightedRoundRobin, AlgoPowerOfChoices, AlgoConsistentHash,
+		AlgoBoundedLoadHash, AlgoRendezvous, AlgoPeakEWMA, AlgoLeastBytes,
+		AlgoSITA, AlgoLeastUtilization, AlgoBandit,
+	} {
+		assert.Contains(t, names, name)
+	}
+
+	assert.Panics(t, func() {
+		Register(AlgoSimpleRoundRobin, func(Options) (LBAlgo, error) {
+			return &RoundRobin{}, nil
+		})
+	})
+}
+
+func TestRegistryNew(t *testing.T) {
+	_, err := New("not-an-algorithm", Options{})
+	assert.NotNil(t, err)
+
+	// defaults without options
+	lbAlgo, err := New(AlgoPowerOfChoices, Options{})
+	assert.Nil(t, err)
+	assert.Equal(t, defaultChoices, lbAlgo.(*PowerOfChoices).Choices)
+
+	// the catalog is required for size aware algorithms
+	_, err = New(AlgoLeastBytes, Options{})
+	assert.NotNil(t, err)
+
+	_, err = New(AlgoSITA, Options{
+		Catalog: func() (map[string]uint64, error) {
+			return nil, errors.New("no file index")
+		},
+	})
+	assert.NotNil(t, err)
+}
+
+func TestRegistrySnapshot(t *testing.T) {
+	catalog := func() (map[string]uint64, error) {
+		return map[string]uint64{}, nil
+	}
+
+	// every algorithm reports all of its nodes, once
+	for _, name := range Registered() {
+		lbAlgo, err := New(name, Options{Catalog: catalog})
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(lbAlgo.Snapshot()), name)
+
+		for i := range 5 {
+			lbAlgo.NodeJoin(&testLoaded{testNodeID{nodeID: uint16(i)}, uint64(i)})
+		}
+
+		states := lbAlgo.Snapshot()
+		assert.Equal(t, 5, len(states), name)
+
+		seen := make(map[uint16]bool)
+		for i, state := range states {
+			assert.Equal(t, i, state.Position, name)
+			assert.Equal(t, uint64(state.ID), state.Active, name)
+			seen[state.ID] = true
+		}
+		assert.Equal(t, 5, len(seen), name)
+	}
+}
+
+func TestRegistryNewOptions(t *testing.T) {
+	// as the load balancer config decodes the options
+	decode := func(options string) func(any) error {
+		return func(v any) error {
+			dec := yaml.NewDecoder(strings.NewReader(options))
+			dec.KnownFields(true)
+			return dec.Decode(v)
+		}
+	}
+
+	lbAlgo, err := New(AlgoBoundedLoadHash, Options{
+		Decode: decode("{virtual-nodes: 10, load-factor: 1.5}"),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 10, lbAlgo.(*BoundedLoadHash).VirtualNodes)
+	assert.Equal(t, 1.5, lbAlgo.(*BoundedLoadHash).LoadFactor)
+
+	for _, loadFactor := range []string{"1", "0.5", "-2"} {
+		_, err = New(AlgoBoundedLoadHash, Options{Decode: decode("load-factor: " + loadFactor)})
+		assert.NotNil(t, err, loadFactor)
+	}
+
+	lbAlgo, err = New(AlgoPeakEWMA, Options{Decode: decode("decay-window: 5s")})
+	assert.Nil(t, err)
+	assert.Equal(t, 5*time.Second, lbAlgo.(*PeakEWMA).DecayWindow)
+
+	catalog := func() (map[string]uint64, error) {
+		return map[string]uint64{"ff": 1}, nil
+	}
+
+	lbAlgo, err = New(AlgoSITA, Options{
+		Decode:  decode("{cutoffs: [1, 2], pools: [1, 1, 1], inner: simple-round-robin}"),
+		Catalog: catalog,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{1, 2}, lbAlgo.(*SITA).Cutoffs)
+	assert.Equal(t, uint64(1), lbAlgo.(*SITA).Sizes["ff"])
+
+	_, err = New(AlgoSITA, Options{
+		Decode:  decode("{cutoffs: [1, 2], pools: [1, 1]}"),
+		Catalog: catalog,
+	})
+	assert.NotNil(t, err)
+
+	_, err = New(AlgoSITA, Options{
+		Decode:  decode("inner: peak-ewma"),
+		Catalog: catalog,
+	})
+	assert.NotNil(t, err)
+
+	_, err = New(AlgoRendezvous, Options{Decode: decode("top-k: [1]")})
+	assert.NotNil(t, err)
+
+	lbAlgo, err = New(AlgoBandit, Options{
+		Decode: decode("{policy: thompson, prior: [2, 3], latency-scale: 1s}"),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, [2]float64{2, 3}, lbAlgo.(*Bandit).Prior)
+	assert.Equal(t, time.Second, lbAlgo.(*Bandit).LatencyScale)
+
+	_, err = New(AlgoBandit, Options{Decode: decode("policy: epsilon-greedy")})
+	assert.NotNil(t, err)
+
+	// misspelled, or meant for another algorithm
+	_, err = New(AlgoConsistentHash, Options{Decode: decode("virtual-node: 10")})
+	assert.NotNil(t, err)
+	_, err = New(AlgoConsistentHash, Options{Decode: decode("load-factor: 1.5")})
+	assert.NotNil(t, err)
+
+	// the algorithm takes no options
+	_, err = New(AlgoLeastConnections, Options{Decode: decode("choices: 2")})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,120 @@
+package algo
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"sort"
+)
+
+func init() {
+	Register(AlgoRendezvous, func(opts Options) (LBAlgo, error) {
+		rv := &Rendezvous{}
+		return rv, opts.Decode(rv)
+	})
+}
+
+// Rendezvous implements KeyedLBAlgo.
+//
+// Highest random weight hashing: every node is scored by hashing its ID along
+// with the key, the node with the highest score owns the key. The next
+// `TopK - 1` highest scoring nodes are the key's fallbacks, in order: when the
+// owner is saturated (as many active requests as its capacity), the key
+// spills to the first fallback that isn't. No ring is needed, scoring all
+// nodes is cheap with small clusters.
+type Rendezvous struct {
+	TopK  int `yaml:"top-k"`
+	nodes []QueueNode
+	rng   *rand.Rand
+}
+
+const defaultTopK = 3
+
+func (rv *Rendezvous) Initialize() {
+	if rv.TopK <= 0 {
+		rv.TopK = defaultTopK
+	}
+	rv.nodes = make([]QueueNode, 0)
+	rv.rng = rand.New(rand.NewSource(rand.Int63()))
+}
+
+func (rv *Rendezvous) NodeJoin(node QueueNode) {
+	rv.nodes = append(rv.nodes, node)
+}
+
+// the keys owned by the node move to their first fallback.
+func (rv *Rendezvous) NodeLeave(node QueueNode) {
+	rv.nodes, _ = removeNode(rv.nodes, node)
+}
+
+// keyless requests are hashed with a random key.
+func (rv *Rendezvous) GetNode() (QueueNode, error) {
+	key := binary.LittleEndian.AppendUint64(nil, rv.rng.Uint64())
+	return rv.GetNodeByKey(key)
+}
+
+// KeyedLBAlgo implementation
+func (rv *Rendezvous) GetNodeByKey(key []byte) (QueueNode, error) {
+	nodes, err := rv.GetNodes(key)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, node := range nodes {
+		if node.ActiveRequests() < uint64(nodeCapacity(node)) {
+			return node, nil
+		}
+	}
+
+	// all saturated, the request waits on the owner
+	return nodes[0], nil
+}
+
+// returns the `TopK` highest scoring nodes for the key, highest first. The
+// first node is the key's owner, the rest are the fallbacks in order.
+func (rv *Rendezvous) GetNodes(key []byte) ([]QueueNode, error) {
+	if len(rv.nodes) == 0 {
+		return nil, errors.New("no node can be scheduled.")
+	}
+
+	type scored struct {
+		node  QueueNode
+		score uint64
+	}
+
+	scores := make([]scored, len(rv.nodes))
+	for i, node := range rv.nodes {
+		scores[i] = scored{node, hashKey(append(nodeKey(node), key...))}
+	}
+
+	sort.Slice(scores, func(i, j int) bool {
+		return scores[i].score > scores[j].score
+	})
+
+	k := min(rv.TopK, len(scores))
+	nodes := make([]QueueNode, k)
+	for i := range nodes {
+		nodes[i] = scores[i].node
+	}
+
+	return nodes, nil
+}
+
+func (rv *Rendezvous) PutNode(node QueueNode) {
+	// nop
+}
+
+func (rv *Rendezvous) Fix(int) error {
+	// nop
+	return nil
+}
+
+func (rv *Rendezvous) Queue() []QueueNode {
+	return rv.nodes
+}
+
+// ordering depends on the key, the nodes are in join order and scored by the
+// active requests.
+func (rv *Rendezvous) Snapshot() []NodeState {
+	return snapshot(rv.nodes, nil, activeScore)
+}
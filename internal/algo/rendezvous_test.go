@@ -0,0 +1,117 @@
+package algo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRendezvousGetNodes(t *testing.T) {
+	rv := Rendezvous{}
+	rv.Initialize()
+	assert.Equal(t, defaultTopK, rv.TopK)
+
+	_, err := rv.GetNodes([]byte("key"))
+	assert.NotNil(t, err)
+
+	// fewer nodes than k
+	rv.NodeJoin(&testNodeID{nodeID: 0})
+	nodes, err := rv.GetNodes([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(nodes))
+
+	for i := 1; i < 20; i++ {
+		rv.NodeJoin(&testNodeID{nodeID: uint16(i)})
+	}
+
+	for _, key := range makeTestKeys(t, 100) {
+		nodes, err := rv.GetNodes(key)
+		assert.Nil(t, err)
+		assert.Equal(t, defaultTopK, len(nodes))
+
+		// distinct fallbacks
+		assert.NotSame(t, nodes[0], nodes[1])
+		assert.NotSame(t, nodes[0], nodes[2])
+		assert.NotSame(t, nodes[1], nodes[2])
+
+		// stable, and the owner is the first node
+		owner, err := rv.GetNodeByKey(key)
+		assert.Nil(t, err)
+		assert.Same(t, nodes[0], owner)
+
+		again, _ := rv.GetNodes(key)
+		assert.Equal(t, nodes, again)
+	}
+}
+
+func TestRendezvousNodeJoinRemap(t *testing.T) {
+	rv := Rendezvous{TopK: 2}
+	rv.Initialize()
+
+	for i := range 10 {
+		rv.NodeJoin(&testNodeID{nodeID: uint16(i)})
+	}
+
+	keys := makeTestKeys(t, 1000)
+	before := make([][]QueueNode, len(keys))
+	for i, key := range keys {
+		before[i], _ = rv.GetNodes(key)
+	}
+
+	newNode := &testNodeID{nodeID: 10}
+	rv.NodeJoin(newNode)
+
+	for i, key := range keys {
+		nodes, _ := rv.GetNodes(key)
+		if nodes[0] != before[i][0] {
+			// the new node took over, the old owner becomes the first fallback
+			assert.Same(t, newNode, nodes[0])
+			assert.Same(t, before[i][0], nodes[1])
+		}
+	}
+}
+
+func TestRendezvousSpill(t *testing.T) {
+	rv := Rendezvous{}
+	rv.Initialize()
+
+	for i := range 10 {
+		rv.NodeJoin(&testCapacitated{testLoaded{testNodeID{nodeID: uint16(i)}, 0}, 2})
+	}
+
+	key := []byte("key")
+	ranked, err := rv.GetNodes(key)
+	assert.Nil(t, err)
+
+	// the owner serves the key up to its capacity
+	for range 2 {
+		node, err := rv.GetNodeByKey(key)
+		assert.Nil(t, err)
+		assert.Same(t, ranked[0], node)
+		node.(*testCapacitated).active++
+	}
+
+	// then it spills to the fallbacks, in order
+	node, _ := rv.GetNodeByKey(key)
+	assert.Same(t, ranked[1], node)
+
+	ranked[1].(*testCapacitated).active = 2
+	node, _ = rv.GetNodeByKey(key)
+	assert.Same(t, ranked[2], node)
+
+	// past the fallbacks, the request waits on the owner
+	ranked[2].(*testCapacitated).active = 2
+	node, _ = rv.GetNodeByKey(key)
+	assert.Same(t, ranked[0], node)
+
+	// a freed slot on the owner takes the key back
+	ranked[0].(*testCapacitated).active = 1
+	node, _ = rv.GetNodeByKey(key)
+	assert.Same(t, ranked[0], node)
+
+	// a suspected owner is taken out, the first fallback is the next in line
+	ranked[1].(*testCapacitated).active = 0
+	rv.NodeLeave(ranked[0])
+	node, _ = rv.GetNodeByKey(key)
+	assert.Same(t, ranked[1], node)
+}
@@ -4,6 +4,12 @@ import (
 	"fmt"
 )
 
+func init() {
+	Register(AlgoSimpleRoundRobin, func(Options) (LBAlgo, error) {
+		return &RoundRobin{}, nil
+	})
+}
+
 // RoundRobin implements LBAlgo.
 type RoundRobin struct {
 	queue []QueueNode
@@ -19,6 +25,23 @@ func (rr *RoundRobin) NodeJoin(node QueueNode) {
 	rr.queue = append(rr.queue, node)
 }
 
+// the node after the one removed keeps its turn.
+func (rr *RoundRobin) NodeLeave(node QueueNode) {
+	var i int
+	rr.queue, i = removeNode(rr.queue, node)
+	if i == -1 {
+		return
+	}
+
+	if i < rr.index {
+		rr.index--
+	}
+
+	if rr.index >= len(rr.queue) {
+		rr.index = 0
+	}
+}
+
 func (rr *RoundRobin) GetNode() (QueueNode, error) {
 	if len(rr.queue) == 0 {
 		return nil, fmt.Errorf("no node can be scheduled.")
@@ -40,6 +63,17 @@ func (rr *RoundRobin) Fix(int) error {
 }
 
 func (rr *RoundRobin) Queue() []QueueNode {
-	// nop
-	return []QueueNode{}
+	return rr.queue
+}
+
+// ordered by turn, the score is the number of picks until the node's turn.
+func (rr *RoundRobin) Snapshot() []NodeState {
+	order := append([]QueueNode(nil), rr.queue[rr.index:]...)
+	order = append(order, rr.queue[:rr.index]...)
+
+	states := snapshot(order, nil, activeScore)
+	for i := range states {
+		states[i].Score = float64(i)
+	}
+	return states
 }
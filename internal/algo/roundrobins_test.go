@@ -21,9 +21,23 @@ func (t *testStruct) SetIndex(i int) {
 }
 
 // testStruct implements QueueNode
-// this is a nop
-func (t *testStruct) Less(QueueNode) bool {
-	return false
+func (t *testStruct) Index() int {
+	return t.index
+}
+
+// testStruct implements QueueNode
+func (t *testStruct) ID() uint16 {
+	return uint16(t.id)
+}
+
+// testStruct implements QueueNode
+func (t *testStruct) ActiveRequests() uint64 {
+	return 0
+}
+
+// testStruct implements QueueNode
+func (t *testStruct) AvgResponseTime() float64 {
+	return 0.0
 }
 
 func TestRoundRobinInitialize(t *testing.T) {
@@ -68,6 +82,65 @@ func TestRoundRobinGetNode(t *testing.T) {
 	assert.Equal(t, rr.index, 0)
 }
 
+func TestRoundRobinNodeLeave(t *testing.T) {
+	rr := RoundRobin{}
+	rr.Initialize()
+
+	nodes := []*testStruct{{id: 0}, {id: 1}, {id: 2}, {id: 3}}
+	for _, node := range nodes {
+		rr.NodeJoin(node)
+	}
+
+	// nodes[2] is next
+	_, _ = rr.GetNode()
+	_, _ = rr.GetNode()
+	assert.Equal(t, 2, rr.index)
+
+	// removing a node before the index keeps the turn
+	rr.NodeLeave(nodes[0])
+	assert.Equal(t, 3, len(rr.queue))
+	node, err := rr.GetNode()
+	assert.Nil(t, err)
+	assert.Same(t, nodes[2], node)
+
+	// removing the last node wraps the index around
+	rr.NodeLeave(nodes[3])
+	assert.Equal(t, 0, rr.index)
+	node, err = rr.GetNode()
+	assert.Nil(t, err)
+	assert.Same(t, nodes[1], node)
+
+	// unknown node is a nop
+	rr.NodeLeave(nodes[3])
+	assert.Equal(t, 2, len(rr.queue))
+
+	rr.NodeLeave(nodes[1])
+	rr.NodeLeave(nodes[2])
+	_, err = rr.GetNode()
+	assert.NotNil(t, err)
+}
+
+func TestRoundRobinSnapshot(t *testing.T) {
+	rr := RoundRobin{}
+	rr.Initialize()
+	assert.Equal(t, 0, len(rr.Snapshot()))
+
+	for i := range 3 {
+		rr.NodeJoin(&testNodeID{nodeID: uint16(i)})
+	}
+
+	_, _ = rr.GetNode()
+
+	// ordered by turn, starting from the next node
+	states := rr.Snapshot()
+	assert.Equal(t, 3, len(states))
+	for i, id := range []uint16{1, 2, 0} {
+		assert.Equal(t, id, states[i].ID)
+		assert.Equal(t, i, states[i].Position)
+		assert.Equal(t, float64(i), states[i].Score)
+	}
+}
+
 func (testt *testStruct) Read(b []byte) (n int, err error) {
 	return 0, nil
 }
@@ -0,0 +1,251 @@
+package algo
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+func init() {
+	Register(AlgoSITA, func(opts Options) (LBAlgo, error) {
+		s := &SITA{}
+		if err := opts.Decode(s); err != nil {
+			return nil, err
+		}
+
+		if err := s.validate(); err != nil {
+			return nil, err
+		}
+
+		var err error
+		s.Sizes, err = opts.Catalog()
+		return s, err
+	})
+}
+
+// SITA implements KeyedLBAlgo.
+//
+// Size interval task assignment: data nodes are partitioned into pools, each
+// pool serving a range of file sizes, so small requests never queue behind
+// large transfers. Pool i serves the files of size <= `Cutoffs[i]`, the last
+// pool serves everything larger. `PoolSizes[i]` is the number of nodes of
+// pool i, nodes are split evenly when unset. Within a pool, requests are
+// scheduled by the `Inner` algorithm, either simple-round-robin or
+// least-connections.
+//
+// The pools see the nodes through a wrapper holding the index within the
+// pool, the index of the node itself is its position in `members`, so a node
+// to fix is found from its index.
+type SITA struct {
+	Sizes     map[string]uint64 `yaml:"-"`
+	Cutoffs   []uint64          `yaml:"cutoffs"`
+	PoolSizes []int             `yaml:"pools"`
+	Inner     string            `yaml:"inner"`
+
+	pools   []LBAlgo
+	nodes   []int // number of nodes in each pool
+	poolOf  map[QueueNode]int
+	members []QueueNode
+	wrapped map[QueueNode]*poolNode
+}
+
+// poolNode is a node as seen by its pool.
+type poolNode struct {
+	QueueNode
+	index int
+}
+
+func (pn *poolNode) SetIndex(i int) {
+	pn.index = i
+}
+
+func (pn *poolNode) Index() int {
+	return pn.index
+}
+
+var defaultCutoffs = []uint64{1 << 20}
+
+func (s *SITA) Initialize() {
+	if s.Sizes == nil {
+		s.Sizes = make(map[string]uint64)
+	}
+	if len(s.Cutoffs) == 0 {
+		s.Cutoffs = defaultCutoffs
+	}
+	if s.Inner == "" {
+		s.Inner = AlgoLeastConnections
+	}
+
+	poolCount := len(s.Cutoffs) + 1
+	s.pools = make([]LBAlgo, poolCount)
+	for i := range s.pools {
+		switch s.Inner {
+		case AlgoSimpleRoundRobin:
+			s.pools[i] = &RoundRobin{}
+		default:
+			s.pools[i] = &LeastConnection{}
+		}
+		s.pools[i].Initialize()
+	}
+
+	s.nodes = make([]int, poolCount)
+	s.poolOf = make(map[QueueNode]int)
+	s.members = make([]QueueNode, 0)
+	s.wrapped = make(map[QueueNode]*poolNode)
+}
+
+func (s *SITA) validate() error {
+	poolCount := len(s.Cutoffs) + 1
+	if len(s.Cutoffs) == 0 {
+		poolCount = len(defaultCutoffs) + 1
+	}
+
+	if len(s.PoolSizes) != 0 && len(s.PoolSizes) != poolCount {
+		return fmt.Errorf(
+			"expected %d pool sizes for %d cutoffs, got %d.",
+			poolCount, poolCount-1, len(s.PoolSizes))
+	}
+
+	switch s.Inner {
+	case "", AlgoSimpleRoundRobin, AlgoLeastConnections:
+		return nil
+	default:
+		return fmt.Errorf("unsupported inner algorithm [%s].", s.Inner)
+	}
+}
+
+// nodes fill up the pools in order, once all pools are full (or if the sizes
+// are unset) the node goes to the pool with the fewest nodes.
+func (s *SITA) NodeJoin(node QueueNode) {
+	pool := -1
+	for i, size := range s.PoolSizes {
+		if s.nodes[i] < size {
+			pool = i
+			break
+		}
+	}
+
+	if pool == -1 {
+		pool = 0
+		for i, n := range s.nodes {
+			if n < s.nodes[pool] {
+				pool = i
+			}
+		}
+	}
+
+	wrapped := &poolNode{node, 0}
+	s.pools[pool].NodeJoin(wrapped)
+	s.nodes[pool]++
+	s.poolOf[node] = pool
+	s.wrapped[node] = wrapped
+
+	node.SetIndex(len(s.members))
+	s.members = append(s.members, node)
+}
+
+func (s *SITA) NodeLeave(node QueueNode) {
+	pool, ok := s.poolOf[node]
+	if !ok {
+		return
+	}
+
+	s.pools[pool].NodeLeave(s.wrapped[node])
+	s.nodes[pool]--
+	delete(s.poolOf, node)
+	delete(s.wrapped, node)
+
+	var i int
+	s.members, i = removeNode(s.members, node)
+	for ; i < len(s.members); i++ {
+		s.members[i].SetIndex(i)
+	}
+	node.SetIndex(-1)
+}
+
+// keyless requests are treated as the smallest files.
+func (s *SITA) GetNode() (QueueNode, error) {
+	return s.getNode(0)
+}
+
+// KeyedLBAlgo implementation
+func (s *SITA) GetNodeByKey(key []byte) (QueueNode, error) {
+	size, ok := s.Sizes[hex.EncodeToString(key)]
+	if !ok {
+		size = unknownFileSize
+	}
+
+	pool := len(s.Cutoffs)
+	for i, cutoff := range s.Cutoffs {
+		if size <= cutoff {
+			pool = i
+			break
+		}
+	}
+
+	return s.getNode(pool)
+}
+
+// schedules on the pool, or the nearest non empty one if it has no node,
+// preferring the pools of larger files.
+func (s *SITA) getNode(pool int) (QueueNode, error) {
+	for i := pool; i < len(s.pools); i++ {
+		if s.nodes[i] != 0 {
+			return s.getPoolNode(i)
+		}
+	}
+
+	for i := pool - 1; i >= 0; i-- {
+		if s.nodes[i] != 0 {
+			return s.getPoolNode(i)
+		}
+	}
+
+	return nil, errors.New("no node can be scheduled.")
+}
+
+func (s *SITA) getPoolNode(pool int) (QueueNode, error) {
+	node, err := s.pools[pool].GetNode()
+	if err != nil {
+		return nil, err
+	}
+	return node.(*poolNode).QueueNode, nil
+}
+
+func (s *SITA) PutNode(node QueueNode) {
+	s.pools[s.poolOf[node]].PutNode(s.wrapped[node])
+}
+
+// only the pool of the node at `i` is fixed, at the node's index in the pool.
+func (s *SITA) Fix(i int) error {
+	if i < 0 || i >= len(s.members) {
+		return errors.New("index i out of bound.")
+	}
+
+	node := s.members[i]
+	return s.pools[s.poolOf[node]].Fix(s.wrapped[node].index)
+}
+
+// the pools' snapshots, from the pool of the smallest files to the largest.
+// Scored by the inner algorithm.
+func (s *SITA) Snapshot() []NodeState {
+	states := make([]NodeState, 0)
+	for _, pool := range s.pools {
+		states = append(states, pool.Snapshot()...)
+	}
+
+	for i := range states {
+		states[i].Position = i
+	}
+	return states
+}
+
+func (s *SITA) Queue() []QueueNode {
+	q := make([]QueueNode, 0)
+	for _, pool := range s.pools {
+		for _, node := range pool.Queue() {
+			q = append(q, node.(*poolNode).QueueNode)
+		}
+	}
+	return q
+}
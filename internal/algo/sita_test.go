@@ -0,0 +1,101 @@
+package algo
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSITAInitialize(t *testing.T) {
+	s := SITA{}
+	s.Initialize()
+	assert.Equal(t, defaultCutoffs, s.Cutoffs)
+	assert.Equal(t, AlgoLeastConnections, s.Inner)
+	assert.Equal(t, 2, len(s.pools))
+}
+
+func TestSITAGetNodeByKey(t *testing.T) {
+	var (
+		small  = []byte{0x01}
+		medium = []byte{0x02}
+		large  = []byte{0x03}
+	)
+
+	s := SITA{
+		Sizes: map[string]uint64{
+			hex.EncodeToString(small):  1 << 8,
+			hex.EncodeToString(medium): 1 << 20,
+			hex.EncodeToString(large):  1 << 30,
+		},
+		Cutoffs:   []uint64{1 << 16, 1 << 24},
+		PoolSizes: []int{1, 2, 1},
+		Inner:     AlgoLeastConnections,
+	}
+	s.Initialize()
+
+	_, err := s.GetNodeByKey(small)
+	assert.NotNil(t, err)
+
+	nodes := make([]*testLoaded, 4)
+	for i := range nodes {
+		nodes[i] = &testLoaded{active: uint64(i)}
+		s.NodeJoin(nodes[i])
+	}
+	assert.Equal(t, []int{1, 2, 1}, s.nodes)
+
+	pick := func(key []byte) QueueNode {
+		node, err := s.GetNodeByKey(key)
+		assert.Nil(t, err)
+		s.PutNode(node)
+		return node
+	}
+
+	for range 10 {
+		assert.Same(t, nodes[0], pick(small))
+		assert.Same(t, nodes[3], pick(large))
+
+		node := pick(medium)
+		assert.True(t, node == nodes[1] || node == nodes[2])
+	}
+
+	// unknown files go to the small pool
+	assert.Same(t, nodes[0], pick([]byte{0xff}))
+
+	// the least connection ordering is kept within a pool
+	nodes[1].active = 10
+	assert.Nil(t, s.Fix(nodes[1].index))
+	assert.Same(t, nodes[2], pick(medium))
+
+	// the index finds the node, whatever its position in its pool
+	s.NodeLeave(nodes[0])
+	assert.Equal(t, -1, nodes[0].index)
+	for i, node := range nodes[1:] {
+		assert.Equal(t, i, node.index)
+	}
+	assert.NotNil(t, s.Fix(len(nodes)-1))
+
+	nodes[1].active, nodes[2].active = 0, 10
+	assert.Nil(t, s.Fix(nodes[2].index))
+	assert.Same(t, nodes[1], pick(medium))
+	assert.Same(t, nodes[3], pick(large))
+}
+
+func TestSITAEmptyPool(t *testing.T) {
+	s := SITA{Cutoffs: []uint64{1 << 16}, PoolSizes: []int{0, 1}}
+	s.Initialize()
+
+	node := &testLoaded{}
+	s.NodeJoin(node)
+
+	// the small pool has no node, the request goes to the large pool
+	found, err := s.GetNode()
+	assert.Nil(t, err)
+	assert.Same(t, node, found)
+	s.PutNode(found)
+
+	s.NodeLeave(node)
+	assert.Equal(t, []int{0, 0}, s.nodes)
+	_, err = s.GetNode()
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,112 @@
+package algo
+
+import (
+	"errors"
+)
+
+func init() {
+	Register(AlgoWeightedRoundRobin, func(Options) (LBAlgo, error) {
+		return &WeightedRoundRobin{}, nil
+	})
+}
+
+// WeightedRoundRobin implements LBAlgo.
+//
+// Smooth weighted round robin, as done in nginx: on every pick, each node's
+// current weight is increased by its effective weight, the node with the
+// highest current weight is selected, then its current weight is reduced by
+// the total weight. Heavier nodes are picked more often, but the picks are
+// spread out instead of being served in bursts.
+type WeightedRoundRobin struct {
+	nodes []*weightedNode
+}
+
+type weightedNode struct {
+	QueueNode
+	weight  int
+	current int
+}
+
+func (wrr *WeightedRoundRobin) Initialize() {
+	wrr.nodes = make([]*weightedNode, 0)
+}
+
+func (wrr *WeightedRoundRobin) NodeJoin(node QueueNode) {
+	wrr.nodes = append(wrr.nodes, &weightedNode{node, nodeWeight(node), 0})
+}
+
+func (wrr *WeightedRoundRobin) NodeLeave(node QueueNode) {
+	for i, n := range wrr.nodes {
+		if n.QueueNode == node {
+			wrr.nodes = append(wrr.nodes[:i], wrr.nodes[i+1:]...)
+			return
+		}
+	}
+}
+
+func (wrr *WeightedRoundRobin) GetNode() (QueueNode, error) {
+	if len(wrr.nodes) == 0 {
+		return nil, errors.New("no node can be scheduled.")
+	}
+
+	var (
+		best  *weightedNode
+		total int
+	)
+
+	for _, node := range wrr.nodes {
+		node.current += node.weight
+		total += node.weight
+
+		if best == nil || node.current > best.current {
+			best = node
+		}
+	}
+
+	best.current -= total
+	return best.QueueNode, nil
+}
+
+func (wrr *WeightedRoundRobin) PutNode(node QueueNode) {
+	// nop
+}
+
+func (wrr *WeightedRoundRobin) Fix(int) error {
+	// nop
+	return nil
+}
+
+func (wrr *WeightedRoundRobin) Queue() []QueueNode {
+	q := make([]QueueNode, len(wrr.nodes))
+	for i, node := range wrr.nodes {
+		q[i] = node.QueueNode
+	}
+	return q
+}
+
+// ordered by the next picks, scored by the current weight + the weight: the
+// highest is picked next.
+func (wrr *WeightedRoundRobin) Snapshot() []NodeState {
+	priority := make(map[QueueNode]float64, len(wrr.nodes))
+	for _, node := range wrr.nodes {
+		priority[node.QueueNode] = float64(node.current + node.weight)
+	}
+
+	less := func(left, right QueueNode) bool {
+		return priority[left] > priority[right]
+	}
+
+	return snapshot(wrr.Queue(), less, func(node QueueNode) float64 {
+		return priority[node]
+	})
+}
+
+// returns the advertised weight of the node, nodes that don't advertise one
+// (or advertise 0) are weighted 1.
+func nodeWeight(node QueueNode) int {
+	w, ok := node.(Weighted)
+	if !ok || w.Weight() == 0 {
+		return 1
+	}
+	return int(w.Weight())
+}
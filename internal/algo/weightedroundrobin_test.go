@@ -0,0 +1,63 @@
+package algo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testWeighted struct {
+	testStruct
+	weight uint16
+}
+
+// Weight implements Weighted.
+func (t *testWeighted) Weight() uint16 {
+	return t.weight
+}
+
+func TestWeightedRoundRobinGetNode(t *testing.T) {
+	wrr := WeightedRoundRobin{}
+	wrr.Initialize()
+
+	_, err := wrr.GetNode()
+	assert.NotNil(t, err)
+
+	a := &testWeighted{weight: 5}
+	b := &testWeighted{weight: 1}
+	c := &testWeighted{weight: 1}
+
+	wrr.NodeJoin(a)
+	wrr.NodeJoin(b)
+	wrr.NodeJoin(c)
+
+	// the sequence from nginx's smooth weighted round robin
+	expected := []QueueNode{a, a, b, a, c, a, a}
+	for _, e := range expected {
+		node, err := wrr.GetNode()
+		assert.Nil(t, err)
+		assert.Same(t, e, node)
+	}
+}
+
+func TestWeightedRoundRobinDistribution(t *testing.T) {
+	wrr := WeightedRoundRobin{}
+	wrr.Initialize()
+
+	nodes := []*testWeighted{{weight: 3}, {weight: 2}, {weight: 0}}
+	for _, node := range nodes {
+		wrr.NodeJoin(node)
+	}
+
+	// 0 weighted node defaults to 1, so a full cycle is 3+2+1 picks.
+	counter := make(map[QueueNode]int)
+	for range 6 * 10 {
+		node, err := wrr.GetNode()
+		assert.Nil(t, err)
+		counter[node]++
+	}
+
+	assert.Equal(t, 30, counter[nodes[0]])
+	assert.Equal(t, 20, counter[nodes[1]])
+	assert.Equal(t, 10, counter[nodes[2]])
+}
@@ -1,32 +1,112 @@
 package config
 
 import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net"
 	"os"
+	"slices"
+	"strconv"
+	"time"
 
 	"github.com/hn275/distributed-storage/internal/database"
+	"github.com/hn275/distributed-storage/internal/health"
+	"github.com/hn275/distributed-storage/internal/network"
+	"github.com/hn275/distributed-storage/internal/qos"
+	"github.com/hn275/distributed-storage/internal/transport"
 	"gopkg.in/yaml.v3"
 )
 
-const DefaultConfigPath = "config/default.yml"
+const (
+	DefaultConfigPath = "config/default.yml"
+	DefaultHost       = "127.0.0.1"
+
+	// the data node dials the user back.
+	ModeDirect = "direct"
+	// the user's connection to the LB stays open, the LB relays the data
+	// node's response on it.
+	ModeRelay = "relay"
+	// the LB replies with the address of the data node, the user dials it.
+	ModeRedirect = "redirect"
+
+	// the connections are left in plaintext.
+	TransportPlain = "plain"
+	// the connections are secured with mutual TLS, see `transport`.
+	TransportTLS = "tls"
+
+	DefaultCertDir = "tmp/certs"
+)
 
 type Config struct {
 	User         userYaml
 	Cluster      clusterYaml
 	LoadBalancer loadbalancerYaml `yaml:"load-balancer"`
 	Experiment   ExperimentYaml
+	Security     SecurityYaml
 }
 
 type clusterYaml struct {
 	Node     uint16
 	Capacity uint16
+	// host the data nodes dial the LB on, either an IP or a host name.
+	// Defaults to `DefaultHost`.
+	LBHost string `yaml:"lb-host"`
+	// host the data nodes listen on for the users in redirect mode,
+	// advertised to them as is. Defaults to `DefaultHost`.
+	Host string `yaml:"host"`
+	// weight advertised by each node in the join message, indexed by node id.
+	Weights []uint16 `yaml:"weights"`
+	// overrides `Capacity` for each node, indexed by node id.
+	Capacities []uint16 `yaml:"capacities"`
+	// order the requests waiting for a slot are served in, by priority class.
+	Scheduler qos.Config `yaml:"scheduler"`
+	// max number of requests waiting for a slot on each node, the others are
+	// turned down with a busy status. Unbounded if 0.
+	QueueSize int `yaml:"queue-size"`
+	// interval of the heartbeats sent to the LB, no heartbeat if 0.
+	HeartbeatInterval time.Duration `yaml:"heartbeat-interval"`
 }
 
 type loadbalancerYaml struct {
 	Algorithm string `yaml:"algo"`
+	// how the data nodes reach the users, `ModeDirect` if empty.
+	Mode string `yaml:"mode"`
+	// host the LB binds to, all interfaces (IPv4 and IPv6) if empty.
+	Host      string `yaml:"host"`
 	LocalPort uint16 `yaml:"local-port"`
+	// overrides the weight advertised by the data nodes, keyed by node id.
+	Weights map[uint16]uint16 `yaml:"weights"`
+	// algorithm specific options, keyed by algorithm name and decoded by the
+	// algorithm itself.
+	Options   map[string]yaml.Node `yaml:"options"`
+	Admission AdmissionYaml        `yaml:"admission"`
+	Affinity  AffinityYaml         `yaml:"affinity"`
+	// detects the failure of the data nodes sending heartbeats.
+	FailureDetector health.Config `yaml:"failure-detector"`
+}
+
+type AdmissionYaml struct {
+	// max number of requests in flight over all nodes, 0 disables admission
+	// control.
+	MaxInFlight uint64 `yaml:"max-in-flight"`
+	// max number of requests waiting for a slot, the others are turned down.
+	// Unbounded if 0, as for the data nodes.
+	QueueSize int `yaml:"queue-size"`
+	// order the waiting requests are dispatched in, by priority class.
+	Scheduler qos.Config `yaml:"scheduler"`
+}
+
+type AffinityYaml struct {
+	// how long a client sticks to its node after its last request, 0
+	// disables affinity.
+	TTL time.Duration `yaml:"ttl"`
 }
 
 type userYaml struct {
+	// host the users listen on for the data nodes, advertised to them as is.
+	// Defaults to `DefaultHost`.
+	Host     string `yaml:"host"`
 	Xsmall   int    `yaml:"x-small"`
 	Small    int    `yaml:"small"`
 	Medium   int    `yaml:"medium"`
@@ -34,6 +114,21 @@ type userYaml struct {
 	Xlarge   int    `yaml:"x-large"`
 	XXlarge  int    `yaml:"xx-large"`
 	Interval uint32 `yaml:"interval"`
+	// number of distinct clients the requests are sent from, one client per
+	// request if 0.
+	Clients int `yaml:"clients"`
+	// files of at least this size are requested as bulk, the others as
+	// interactive. All requests are interactive if 0.
+	BulkSize uint64 `yaml:"bulk-size"`
+}
+
+type SecurityYaml struct {
+	// how the connections between the components are secured,
+	// `TransportPlain` if empty.
+	Transport string `yaml:"transport"`
+	// directory of the certificates generated by cmd/gen-certs, defaults to
+	// `DefaultCertDir`.
+	CertDir string `yaml:"cert-dir"`
 }
 
 type ExperimentYaml struct {
@@ -43,12 +138,172 @@ type ExperimentYaml struct {
 	OverheadParam int64 `yaml:"overhead-param"`
 }
 
+// returns the decoder of the options of the algorithm `name`, nil if there's
+// none. Keys the algorithm doesn't know of are rejected.
+func (lb *loadbalancerYaml) OptionsDecoder(name string) func(v any) error {
+	node, ok := lb.Options[name]
+	if !ok {
+		return nil
+	}
+
+	return func(v any) error {
+		buf, err := yaml.Marshal(&node)
+		if err != nil {
+			return err
+		}
+
+		dec := yaml.NewDecoder(bytes.NewReader(buf))
+		dec.KnownFields(true)
+		return dec.Decode(v)
+	}
+}
+
+// checks the options are keyed by the names of `algorithms`.
+func (lb *loadbalancerYaml) CheckOptions(algorithms []string) error {
+	for name := range lb.Options {
+		if !slices.Contains(algorithms, name) {
+			return fmt.Errorf("options for unsupported algorithm [%s].", name)
+		}
+	}
+	return nil
+}
+
 func NewConfig(configPath string) (*Config, error) {
 	conf := &Config{}
 	err := readConfig(conf, configPath)
 	return conf, err
 }
 
+// returns the topology the requests are served in.
+func (lb *loadbalancerYaml) GetMode() (string, error) {
+	switch lb.Mode {
+	case "", ModeDirect:
+		return ModeDirect, nil
+	case ModeRelay, ModeRedirect:
+		return lb.Mode, nil
+	default:
+		return "", fmt.Errorf("unsupported mode [%s].", lb.Mode)
+	}
+}
+
+// returns the transport of the component holding the certificate of `role`.
+func (s *SecurityYaml) NewTransport(role string) (*transport.Transport, error) {
+	switch s.Transport {
+	case "", TransportPlain:
+		return transport.Plain(), nil
+	case TransportTLS:
+		certDir := s.CertDir
+		if certDir == "" {
+			certDir = DefaultCertDir
+		}
+		return transport.New(certDir, role)
+	default:
+		return nil, fmt.Errorf("unsupported transport [%s].", s.Transport)
+	}
+}
+
+// returns the address the LB listens on.
+func (lb *loadbalancerYaml) ListenAddr() string {
+	return net.JoinHostPort(lb.Host, strconv.Itoa(int(lb.LocalPort)))
+}
+
+// returns the address the data nodes dial the LB on.
+func (c *Config) LBAddr() string {
+	return net.JoinHostPort(
+		orDefault(c.Cluster.LBHost), strconv.Itoa(int(c.LoadBalancer.LocalPort)))
+}
+
+// returns the address of the data nodes' listeners, on a random port.
+func (c *clusterYaml) ListenAddr() string {
+	return net.JoinHostPort(orDefault(c.Host), "0")
+}
+
+// returns the address of the users' listeners, on a random port.
+func (u *userYaml) ListenAddr() string {
+	return net.JoinHostPort(orDefault(u.Host), "0")
+}
+
+// returns the weight of the node `nodeID`, defaults to 1.
+func (c *clusterYaml) GetWeight(nodeID uint16) uint16 {
+	if int(nodeID) >= len(c.Weights) || c.Weights[nodeID] == 0 {
+		return 1
+	}
+	return c.Weights[nodeID]
+}
+
+// returns the capacity of the node `nodeID`, defaults to `Capacity`.
+func (c *clusterYaml) GetCapacity(nodeID uint16) uint16 {
+	if int(nodeID) >= len(c.Capacities) || c.Capacities[nodeID] == 0 {
+		return c.Capacity
+	}
+	return c.Capacities[nodeID]
+}
+
+// returns the interval of the heartbeats, sent in whole milliseconds. An
+// interval under 1ms would be sent as 0, which is no heartbeat.
+func (c *clusterYaml) GetHeartbeat() (time.Duration, error) {
+	if c.HeartbeatInterval < 0 ||
+		(c.HeartbeatInterval > 0 && c.HeartbeatInterval < time.Millisecond) {
+		return 0, fmt.Errorf(
+			"heartbeat interval must be at least 1ms, got %v.", c.HeartbeatInterval)
+	}
+	return c.HeartbeatInterval, nil
+}
+
+// returns the max number of requests waiting for a slot on a node.
+func (c *clusterYaml) GetQueueSize() int {
+	if c.QueueSize <= 0 {
+		return qos.Unbounded
+	}
+	return c.QueueSize
+}
+
+// returns the max number of requests waiting for a slot at the LB.
+func (a *AdmissionYaml) GetQueueSize() int {
+	if a.QueueSize <= 0 {
+		return qos.Unbounded
+	}
+	return a.QueueSize
+}
+
+// returns the time the node `nodeID` sleeps before serving a request, 0 for
+// homogeneous clusters.
+func (e *ExperimentYaml) GetOverhead(nodeID uint16) time.Duration {
+	if e.Homogeneous {
+		return 0
+	}
+
+	// For the sake of replicability and to ensure nodes are "heterogeneous" enough,
+	// we keep the first 10 nodes hardcoded for the heterogeneous case.
+	// If you want to change this behaviour, you need to modify these if statements
+	if nodeID < 3 {
+		return time.Millisecond * (10)
+	} else if nodeID < 6 {
+		return time.Millisecond * (500)
+	} else if nodeID < 10 {
+		return time.Millisecond * (900)
+	}
+
+	rng := rand.New(rand.NewSource(int64(nodeID)))
+	return time.Millisecond * time.Duration(rng.Int63n(e.OverheadParam))
+}
+
+// returns the id of the client sending the request number `requestIdx`.
+func (u *userYaml) GetClientID(requestIdx int) uint64 {
+	if u.Clients <= 0 {
+		return uint64(requestIdx)
+	}
+	return uint64(requestIdx % u.Clients)
+}
+
+// returns the priority class of a request for a file of `size` bytes.
+func (u *userYaml) GetClass(size uint64) uint8 {
+	if u.BulkSize == 0 || size < u.BulkSize {
+		return network.PriorityInteractive
+	}
+	return network.PriorityBulk
+}
+
 func (u *userYaml) GetFiles(db *database.FileIndex) map[string]int {
 	files := make(map[string]int)
 
@@ -62,6 +317,13 @@ func (u *userYaml) GetFiles(db *database.FileIndex) map[string]int {
 	return files
 }
 
+func orDefault(host string) string {
+	if host == "" {
+		return DefaultHost
+	}
+	return host
+}
+
 func readConfig(confBuf *Config, filePath string) error {
 	fd, err := os.OpenFile(filePath, os.O_RDONLY, 0666)
 	if err != nil {
@@ -4,6 +4,7 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"errors"
+	"fmt"
 	"io"
 
 	"golang.org/x/crypto/chacha20poly1305"
@@ -14,6 +15,9 @@ const (
 	blockOverhead = NonceSize + TagSize
 )
 
+// the file can't be authenticated, either corrupted or the wrong key.
+var ErrDecrypt = errors.New("failed to decrypt file")
+
 type FileStream struct {
 	block  cipher.AEAD
 	pubKey []byte
@@ -57,7 +61,7 @@ func (f *FileStream) DecryptAndCopy(dst io.Writer, src io.Reader) (int, error) {
 
 		buf, err = f.block.Open(buf, nonce, ciphertext, f.pubKey)
 		if err != nil {
-			return bytesDecrypted, err
+			return bytesDecrypted, fmt.Errorf("%w: %v", ErrDecrypt, err)
 		}
 
 		// copy to dst
@@ -72,6 +76,13 @@ func (f *FileStream) DecryptAndCopy(dst io.Writer, src io.Reader) (int, error) {
 	return bytesDecrypted, nil
 }
 
+// returns the size of the plaintext of a file of `size` bytes, as written by
+// EncryptAndCopy.
+func PlaintextSize(size int64) int64 {
+	blockCount := (size + blockSize + blockOverhead - 1) / (blockSize + blockOverhead)
+	return max(size-blockCount*blockOverhead, 0)
+}
+
 func (f FileStream) EncryptAndCopy(dst io.Writer, src io.Reader, hasher io.Writer) (int, error) {
 	bytesEncrypted := 0
 
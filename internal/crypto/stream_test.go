@@ -56,6 +56,7 @@ func runTest(t *testing.T, fileSize int) {
 	assert.Nil(t, err)
 
 	digest1 := h.Sum(nil)
+	assert.Equal(t, int64(fileSize), PlaintextSize(int64(cBuf.Len())))
 
 	// check for valid hash
 	assert.True(t, bytesEqual(expectedDigest, digest1))
@@ -77,6 +78,23 @@ func runTest(t *testing.T, fileSize int) {
 	assert.True(t, bytesEqual(expectedDigest, digest2))
 }
 
+func TestFileStreamWrongKey(t *testing.T) {
+	streamer, err := NewFileStream(DataNodeSecretKey[:], UserPublicKey[:])
+	assert.Nil(t, err)
+
+	cBuf := &bytes.Buffer{}
+	_, err = streamer.EncryptAndCopy(cBuf, bytes.NewReader([]byte("hello")), io.Discard)
+	assert.Nil(t, err)
+
+	wrongKey := [32]byte{}
+	streamer, err = NewFileStream(DataNodeSecretKey[:], wrongKey[:])
+	assert.Nil(t, err)
+
+	n, err := streamer.DecryptAndCopy(io.Discard, cBuf)
+	assert.ErrorIs(t, err, ErrDecrypt)
+	assert.Equal(t, 0, n)
+}
+
 func bytesEqual(a, b []byte) bool {
 	if len(a) != len(b) {
 		return false
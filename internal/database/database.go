@@ -29,6 +29,16 @@ func (p Path) Append(path string) Path {
 	return Path(string(p) + "/" + path)
 }
 
+// plaintext size of each file, as generated by `data-gen`.
+const (
+	SizeXsmall  = 1 << 8
+	SizeSmall   = 1 << 16
+	SizeMedium  = 1 << 20
+	SizeLarge   = 1 << 24
+	SizeXlarge  = 1 << 28
+	SizeXXlarge = 1 << 30
+)
+
 // file addressing
 
 type FileIndex struct {
@@ -53,3 +63,15 @@ func NewFileIndex() (*FileIndex, error) {
 
 	return fileIndex, err
 }
+
+// returns the size of each file, keyed by the file name (hex encoded digest).
+func (f *FileIndex) Catalog() map[string]uint64 {
+	return map[string]uint64{
+		f.Xsmall:  SizeXsmall,
+		f.Small:   SizeSmall,
+		f.Medium:  SizeMedium,
+		f.Large:   SizeLarge,
+		f.Xlarge:  SizeXlarge,
+		f.XXlarge: SizeXXlarge,
+	}
+}
@@ -0,0 +1,99 @@
+package health
+
+import (
+	"math"
+	"time"
+)
+
+type timeout struct {
+	interval time.Duration
+	last     time.Time
+}
+
+func (t *timeout) heartbeat(now time.Time) {
+	t.last = now
+}
+
+func (t *timeout) suspicion(now time.Time) float64 {
+	return max(float64(now.Sub(t.last))/float64(t.interval), 0)
+}
+
+// the inter-arrival times are modeled as a normal distribution, estimated on
+// a sliding window. φ = -log10(P(no heartbeat for as long)), a φ of 3 is a
+// 0.1% chance of a false positive.
+type phiAccrual struct {
+	last time.Time
+
+	// ring of the past inter-arrival times, in nanoseconds
+	samples []float64
+	next    int
+	sum     float64
+	sumSq   float64
+
+	// the deviation is kept over a floor, or a steady node is declared dead
+	// on the slightest delay
+	minStdDev float64
+}
+
+// the window starts off with two samples around `interval`, so the node can
+// be suspected before its first heartbeat.
+func newPhiAccrual(interval time.Duration, window int, now time.Time) *phiAccrual {
+	p := &phiAccrual{
+		last:      now,
+		samples:   make([]float64, 0, max(window, 2)),
+		minStdDev: float64(interval) / 2,
+	}
+
+	d := float64(interval) / 2
+	p.add(float64(interval) - d)
+	p.add(float64(interval) + d)
+	return p
+}
+
+func (p *phiAccrual) heartbeat(now time.Time) {
+	if elapsed := now.Sub(p.last); elapsed > 0 {
+		p.add(float64(elapsed))
+	}
+	p.last = now
+}
+
+func (p *phiAccrual) add(sample float64) {
+	if len(p.samples) < cap(p.samples) {
+		p.samples = append(p.samples, sample)
+	} else {
+		old := p.samples[p.next]
+		p.sum -= old
+		p.sumSq -= old * old
+		p.samples[p.next] = sample
+		p.next = (p.next + 1) % len(p.samples)
+	}
+
+	p.sum += sample
+	p.sumSq += sample * sample
+}
+
+func (p *phiAccrual) suspicion(now time.Time) float64 {
+	n := float64(len(p.samples))
+	mean := p.sum / n
+	variance := max(p.sumSq/n-mean*mean, 0)
+	stdDev := max(math.Sqrt(variance), p.minStdDev)
+
+	return phi(float64(now.Sub(p.last)), mean, stdDev)
+}
+
+// -log10 of the upper tail of N(mean, stdDev) at `t`, with the logistic
+// approximation of the normal CDF, accurate to ~1e-4.
+func phi(t, mean, stdDev float64) float64 {
+	y := (t - mean) / stdDev
+	e := math.Exp(-y * (1.5976 + 0.070566*y*y))
+
+	// both are the same tail, the second one holds when `e` overflows
+	var tail float64
+	if t > mean {
+		tail = e / (1 + e)
+	} else {
+		tail = 1 - 1/(1+e)
+	}
+
+	return max(-math.Log10(tail), 0)
+}
@@ -0,0 +1,148 @@
+// Package health detects the failure of a node from its heartbeats. The
+// detectors output a level of suspicion, that grows with the time since the
+// last heartbeat. A node over the suspect threshold is suspected, over the dead
+// threshold it's declared dead.
+package health
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// the suspicion is the number of heartbeat intervals since the last
+	// heartbeat.
+	PolicyTimeout = "timeout"
+	// the suspicion is φ, from the distribution of the past heartbeat
+	// inter-arrival times (Hayashibara et al.).
+	PolicyPhiAccrual = "phi-accrual"
+
+	defaultWindow = 100
+)
+
+type State int
+
+const (
+	Alive State = iota
+	Suspect
+	Dead
+)
+
+func (s State) String() string {
+	switch s {
+	case Alive:
+		return "alive"
+	case Suspect:
+		return "suspect"
+	case Dead:
+		return "dead"
+	default:
+		return fmt.Sprintf("state-%d", int(s))
+	}
+}
+
+type Config struct {
+	// failure detection is disabled if empty.
+	Policy string `yaml:"policy"`
+	// levels of suspicion a node is suspected, and declared dead at.
+	// Defaults to 3 and 6 intervals for `PolicyTimeout`, φ of 3 and 8 for
+	// `PolicyPhiAccrual`.
+	SuspectThreshold float64 `yaml:"suspect-threshold"`
+	DeadThreshold    float64 `yaml:"dead-threshold"`
+	// phi-accrual only, number of inter-arrival times the distribution is
+	// estimated on. Defaults to 100.
+	Window int `yaml:"window"`
+}
+
+func (c *Config) Enabled() bool {
+	return c.Policy != ""
+}
+
+// checks the policy and the thresholds, once their defaults are set.
+func (c *Config) Validate() error {
+	_, err := New(*c, time.Second, time.Now())
+	return err
+}
+
+// Detector tracks the heartbeats of a single node, not thread safe.
+type Detector struct {
+	estimator
+	suspect float64
+	dead    float64
+}
+
+type estimator interface {
+	heartbeat(now time.Time)
+	// level of suspicion at `now`, never negative
+	suspicion(now time.Time) float64
+}
+
+// the node is expected to send a heartbeat every `interval`, starting from
+// `now`.
+func New(conf Config, interval time.Duration, now time.Time) (*Detector, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid heartbeat interval: %v.", interval)
+	}
+
+	var (
+		est           estimator
+		suspect, dead float64
+	)
+
+	switch conf.Policy {
+	case PolicyTimeout:
+		est = &timeout{interval, now}
+		suspect, dead = 3, 6
+
+	case PolicyPhiAccrual:
+		window := conf.Window
+		if window == 0 {
+			window = defaultWindow
+		}
+		if window < 0 {
+			return nil, fmt.Errorf("invalid window size: %d.", window)
+		}
+		est = newPhiAccrual(interval, window, now)
+		suspect, dead = 3, 8
+
+	default:
+		return nil, fmt.Errorf("unsupported failure detector [%s].", conf.Policy)
+	}
+
+	if conf.SuspectThreshold != 0 {
+		suspect = conf.SuspectThreshold
+	}
+	if conf.DeadThreshold != 0 {
+		dead = conf.DeadThreshold
+	}
+
+	if suspect <= 0 || dead <= suspect {
+		return nil, fmt.Errorf(
+			"expected 0 < suspect-threshold < dead-threshold, got %g and %g.",
+			suspect, dead)
+	}
+
+	return &Detector{est, suspect, dead}, nil
+}
+
+// records a heartbeat received at `now`.
+func (d *Detector) Heartbeat(now time.Time) {
+	d.heartbeat(now)
+}
+
+// returns the level of suspicion of the node at `now`.
+func (d *Detector) Suspicion(now time.Time) float64 {
+	return d.suspicion(now)
+}
+
+// returns the state of the node at `now`, from its level of suspicion.
+func (d *Detector) State(now time.Time) State {
+	switch s := d.suspicion(now); {
+	case s >= d.dead:
+		return Dead
+	case s >= d.suspect:
+		return Suspect
+	default:
+		return Alive
+	}
+}
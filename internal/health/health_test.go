@@ -0,0 +1,88 @@
+package health
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var epoch = time.Unix(0, 0)
+
+func at(d time.Duration) time.Time {
+	return epoch.Add(d)
+}
+
+func TestTimeoutDetector(t *testing.T) {
+	d, err := New(Config{Policy: PolicyTimeout}, time.Second, epoch)
+	assert.Nil(t, err)
+
+	assert.Equal(t, Alive, d.State(at(2*time.Second)))
+	assert.Equal(t, Suspect, d.State(at(3*time.Second)))
+	assert.Equal(t, Dead, d.State(at(6*time.Second)))
+
+	// a late heartbeat clears the suspicion
+	d.Heartbeat(at(5 * time.Second))
+	assert.Equal(t, Alive, d.State(at(5*time.Second)))
+	assert.Equal(t, 0.5, d.Suspicion(at(5500*time.Millisecond)))
+}
+
+func TestPhiAccrualDetector(t *testing.T) {
+	d, err := New(Config{Policy: PolicyPhiAccrual}, time.Second, epoch)
+	assert.Nil(t, err)
+
+	// steady heartbeats, the suspicion grows past the usual interval
+	now := time.Duration(0)
+	for range 50 {
+		now += time.Second
+		d.Heartbeat(at(now))
+	}
+
+	assert.Equal(t, Alive, d.State(at(now+time.Second)))
+	assert.Less(t, d.Suspicion(at(now+time.Second)), d.Suspicion(at(now+2*time.Second)))
+	assert.Equal(t, Suspect, d.State(at(now+3*time.Second)))
+	assert.Equal(t, Dead, d.State(at(now+5*time.Second)))
+	assert.True(t, math.IsInf(d.Suspicion(at(now+time.Hour)), 1))
+}
+
+func TestPhiAccrualJitter(t *testing.T) {
+	steady, err := New(Config{Policy: PolicyPhiAccrual, Window: 10}, time.Second, epoch)
+	assert.Nil(t, err)
+	jittery, err := New(Config{Policy: PolicyPhiAccrual, Window: 10}, time.Second, epoch)
+	assert.Nil(t, err)
+
+	// the same average interval, the jittery node is given more slack
+	now := time.Duration(0)
+	for i := range 20 {
+		now += time.Second
+		steady.Heartbeat(at(now))
+		jittery.Heartbeat(at(now + time.Duration(i%2)*600*time.Millisecond))
+	}
+
+	now += time.Second
+	steady.Heartbeat(at(now))
+	jittery.Heartbeat(at(now))
+
+	late := at(now + 3*time.Second)
+	assert.Less(t, jittery.Suspicion(late), steady.Suspicion(late))
+}
+
+func TestDetectorConfig(t *testing.T) {
+	for _, conf := range []Config{
+		{Policy: "gossip"},
+		{Policy: PolicyTimeout, SuspectThreshold: 5, DeadThreshold: 4},
+		{Policy: PolicyTimeout, SuspectThreshold: -1},
+		{Policy: PolicyPhiAccrual, Window: -1},
+	} {
+		assert.NotNil(t, conf.Validate(), conf)
+	}
+
+	_, err := New(Config{Policy: PolicyTimeout}, 0, epoch)
+	assert.NotNil(t, err)
+
+	conf := Config{Policy: PolicyPhiAccrual, SuspectThreshold: 1, DeadThreshold: 2}
+	assert.Nil(t, conf.Validate())
+	assert.True(t, conf.Enabled())
+	assert.False(t, (&Config{}).Enabled())
+}
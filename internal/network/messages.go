@@ -0,0 +1,403 @@
+package network
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"time"
+)
+
+// DataNodeJoin: data node -> LB, once connected.
+type DataNodeJoinMsg struct {
+	NodeID   uint16
+	Weight   uint16
+	Capacity uint16
+	// interval of the node's heartbeats, in milliseconds on the wire. 0 if
+	// the node doesn't send any.
+	Heartbeat time.Duration
+	Addr      *net.TCPAddr // the node's listener, redirect mode only
+}
+
+// UserNodeJoin: user -> LB, forwarded as is to the data node picked.
+type UserNodeJoinMsg struct {
+	Addr     *net.TCPAddr // the listener dialed by the data node, nil if none
+	Digest   [32]byte     // file requested
+	ClientID uint64
+	Class    uint8 // priority class
+}
+
+// HealthCheck: data node -> LB, after every request served.
+type HealthCheckMsg struct {
+	AvgRT   float64       // in nanoseconds
+	Latency time.Duration // of the request served
+	Digest  [32]byte      // file served
+	Status  uint8         // of the file response
+}
+
+// Heartbeat: data node -> LB, every heartbeat interval advertised on join.
+type HeartbeatMsg struct {
+	Seq uint64 // counts up from 0, a gap is a heartbeat lost
+}
+
+// ShutdownSig: user -> LB, at the end of the simulation.
+type ShutdownSigMsg struct{}
+
+// RequestAccepted: LB -> user, or LB -> admin on a successful algorithm
+// switch.
+type RequestAcceptedMsg struct{}
+
+// ServerBusy: LB -> user, the request is turned down.
+type ServerBusyMsg struct{}
+
+// AlgoSwitch: admin -> LB.
+type AlgoSwitchMsg struct {
+	Algorithm string
+}
+
+// Redirect: LB -> user, the data node to request the file from.
+type RedirectMsg struct {
+	Addr *net.TCPAddr
+}
+
+// FileRequest: user -> data node, once the data node dialed in.
+type FileRequestMsg struct {
+	Digest [32]byte
+	PubKey [32]byte
+}
+
+// FileResponse: data node -> user, ahead of the file content.
+type FileResponseMsg struct {
+	Status uint8
+	Length uint64 // of the content following, 0 unless StatusOK
+	Error  string // empty unless an error status
+}
+
+// the file response is an error status.
+type StatusError struct {
+	Status uint8
+	Msg    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: %s", StatusText(e.Status), e.Msg)
+}
+
+// payload sizes
+const (
+	// [node id:2][weight:2][capacity:2][heartbeat:4][addr len:1][addr]
+	dataNodeJoinSize = 2 + 2 + 2 + 4 + 1
+	// [addr len:1][addr][digest:32][client id:8][class:1]
+	userNodeJoinSize = 1 + 32 + 8 + 1
+	healthCheckSize  = 8 + 8 + 32 + 1
+	heartbeatSize    = 8
+	fileRequestSize  = 32 + 32
+	// [status:1][length:8][error message]
+	fileResponseSize = 1 + 8
+)
+
+func (m *DataNodeJoinMsg) Type() uint8 {
+	return DataNodeJoin
+}
+
+func (m *DataNodeJoinMsg) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, dataNodeJoinSize)
+	BinaryEndianess.PutUint16(buf[0:2], m.NodeID)
+	BinaryEndianess.PutUint16(buf[2:4], m.Weight)
+	BinaryEndianess.PutUint16(buf[4:6], m.Capacity)
+
+	heartbeat := m.Heartbeat.Milliseconds()
+	if heartbeat < 0 || heartbeat > math.MaxUint32 {
+		return nil, fmt.Errorf("heartbeat interval out of range: %v", m.Heartbeat)
+	}
+	BinaryEndianess.PutUint32(buf[6:10], uint32(heartbeat))
+
+	return appendAddr(buf, 10, m.Addr)
+}
+
+func (m *DataNodeJoinMsg) UnmarshalBinary(buf []byte) error {
+	addr, n, err := parseAddr(m, buf, 10)
+	if err != nil {
+		return err
+	}
+
+	if err := checkSize(m, buf, dataNodeJoinSize+n); err != nil {
+		return err
+	}
+
+	m.NodeID = BinaryEndianess.Uint16(buf[0:2])
+	m.Weight = BinaryEndianess.Uint16(buf[2:4])
+	m.Capacity = BinaryEndianess.Uint16(buf[4:6])
+	m.Heartbeat = time.Duration(BinaryEndianess.Uint32(buf[6:10])) * time.Millisecond
+	m.Addr = addr
+	return nil
+}
+
+func (m *UserNodeJoinMsg) Type() uint8 {
+	return UserNodeJoin
+}
+
+func (m *UserNodeJoinMsg) MarshalBinary() ([]byte, error) {
+	buf, err := appendAddr(make([]byte, 1), 0, m.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make([]byte, userNodeJoinSize-1)
+	copy(fields[0:32], m.Digest[:])
+	BinaryEndianess.PutUint64(fields[32:40], m.ClientID)
+	fields[40] = m.Class
+	return append(buf, fields...), nil
+}
+
+func (m *UserNodeJoinMsg) UnmarshalBinary(buf []byte) error {
+	addr, n, err := parseAddr(m, buf, 0)
+	if err != nil {
+		return err
+	}
+
+	if err := checkSize(m, buf, userNodeJoinSize+n); err != nil {
+		return err
+	}
+
+	fields := buf[1+n:]
+	m.Addr = addr
+	copy(m.Digest[:], fields[0:32])
+	m.ClientID = BinaryEndianess.Uint64(fields[32:40])
+	m.Class = fields[40]
+	return nil
+}
+
+func (m *HealthCheckMsg) Type() uint8 {
+	return HealthCheck
+}
+
+func (m *HealthCheckMsg) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, healthCheckSize)
+	BinaryEndianess.PutUint64(buf[0:8], math.Float64bits(m.AvgRT))
+	BinaryEndianess.PutUint64(buf[8:16], uint64(m.Latency))
+	copy(buf[16:48], m.Digest[:])
+	buf[48] = m.Status
+	return buf, nil
+}
+
+func (m *HealthCheckMsg) UnmarshalBinary(buf []byte) error {
+	if err := checkSize(m, buf, healthCheckSize); err != nil {
+		return err
+	}
+
+	m.AvgRT = math.Float64frombits(BinaryEndianess.Uint64(buf[0:8]))
+	m.Latency = time.Duration(BinaryEndianess.Uint64(buf[8:16]))
+	copy(m.Digest[:], buf[16:48])
+	m.Status = buf[48]
+	return nil
+}
+
+func (m *HeartbeatMsg) Type() uint8 {
+	return Heartbeat
+}
+
+func (m *HeartbeatMsg) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, heartbeatSize)
+	BinaryEndianess.PutUint64(buf, m.Seq)
+	return buf, nil
+}
+
+func (m *HeartbeatMsg) UnmarshalBinary(buf []byte) error {
+	if err := checkSize(m, buf, heartbeatSize); err != nil {
+		return err
+	}
+
+	m.Seq = BinaryEndianess.Uint64(buf)
+	return nil
+}
+
+func (m *ShutdownSigMsg) Type() uint8 {
+	return ShutdownSig
+}
+
+func (m *ShutdownSigMsg) MarshalBinary() ([]byte, error) {
+	return nil, nil
+}
+
+func (m *ShutdownSigMsg) UnmarshalBinary(buf []byte) error {
+	return checkSize(m, buf, 0)
+}
+
+func (m *RequestAcceptedMsg) Type() uint8 {
+	return RequestAccepted
+}
+
+func (m *RequestAcceptedMsg) MarshalBinary() ([]byte, error) {
+	return nil, nil
+}
+
+func (m *RequestAcceptedMsg) UnmarshalBinary(buf []byte) error {
+	return checkSize(m, buf, 0)
+}
+
+func (m *ServerBusyMsg) Type() uint8 {
+	return ServerBusy
+}
+
+func (m *ServerBusyMsg) MarshalBinary() ([]byte, error) {
+	return nil, nil
+}
+
+func (m *ServerBusyMsg) UnmarshalBinary(buf []byte) error {
+	return checkSize(m, buf, 0)
+}
+
+func (m *AlgoSwitchMsg) Type() uint8 {
+	return AlgoSwitch
+}
+
+func (m *AlgoSwitchMsg) MarshalBinary() ([]byte, error) {
+	if m.Algorithm == "" {
+		return nil, fmt.Errorf("empty algorithm name")
+	}
+	return []byte(m.Algorithm), nil
+}
+
+func (m *AlgoSwitchMsg) UnmarshalBinary(buf []byte) error {
+	if len(buf) == 0 {
+		return fmt.Errorf("empty algorithm name")
+	}
+	m.Algorithm = string(buf)
+	return nil
+}
+
+func (m *RedirectMsg) Type() uint8 {
+	return Redirect
+}
+
+func (m *RedirectMsg) MarshalBinary() ([]byte, error) {
+	if m.Addr == nil {
+		return nil, fmt.Errorf("missing redirect address")
+	}
+	return appendAddr(make([]byte, 1), 0, m.Addr)
+}
+
+func (m *RedirectMsg) UnmarshalBinary(buf []byte) error {
+	addr, n, err := parseAddr(m, buf, 0)
+	if err != nil {
+		return err
+	}
+
+	if err := checkSize(m, buf, 1+n); err != nil {
+		return err
+	}
+
+	if addr == nil {
+		return fmt.Errorf("missing redirect address")
+	}
+
+	m.Addr = addr
+	return nil
+}
+
+func (m *FileRequestMsg) Type() uint8 {
+	return FileRequest
+}
+
+func (m *FileRequestMsg) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, fileRequestSize)
+	copy(buf[0:32], m.Digest[:])
+	copy(buf[32:64], m.PubKey[:])
+	return buf, nil
+}
+
+func (m *FileRequestMsg) UnmarshalBinary(buf []byte) error {
+	if err := checkSize(m, buf, fileRequestSize); err != nil {
+		return err
+	}
+
+	copy(m.Digest[:], buf[0:32])
+	copy(m.PubKey[:], buf[32:64])
+	return nil
+}
+
+func (m *FileResponseMsg) Type() uint8 {
+	return FileResponse
+}
+
+func (m *FileResponseMsg) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, fileResponseSize, fileResponseSize+len(m.Error))
+	buf[0] = m.Status
+	BinaryEndianess.PutUint64(buf[1:9], m.Length)
+	return append(buf, m.Error...), nil
+}
+
+func (m *FileResponseMsg) UnmarshalBinary(buf []byte) error {
+	if len(buf) < fileResponseSize {
+		return checkSize(m, buf, fileResponseSize)
+	}
+
+	m.Status = buf[0]
+	m.Length = BinaryEndianess.Uint64(buf[1:9])
+	m.Error = string(buf[fileResponseSize:])
+	return nil
+}
+
+// returns a StatusError if the response isn't StatusOK.
+func (m *FileResponseMsg) Err() error {
+	if m.Status == StatusOK {
+		return nil
+	}
+	return &StatusError{m.Status, m.Error}
+}
+
+// writes the optional address `addr` at `buf[offset]`, as
+// [addr len:1][addr]. `buf` holds the length byte already.
+func appendAddr(buf []byte, offset int, addr *net.TCPAddr) ([]byte, error) {
+	if addr == nil {
+		buf[offset] = 0
+		return buf, nil
+	}
+
+	size, err := AddrSize(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	buf[offset] = uint8(size)
+	encoded := make([]byte, size)
+	if err := AddrToBytes(addr, encoded); err != nil {
+		return nil, err
+	}
+
+	return append(buf, encoded...), nil
+}
+
+// reads the optional address at `buf[offset]`, returns the address and its
+// size.
+func parseAddr(msg Message, buf []byte, offset int) (*net.TCPAddr, int, error) {
+	if len(buf) <= offset {
+		return nil, 0, fmt.Errorf("invalid payload size for message type %d: %d",
+			msg.Type(), len(buf))
+	}
+
+	size := int(buf[offset])
+	if size == 0 {
+		return nil, 0, nil
+	}
+
+	if len(buf) < offset+1+size {
+		return nil, 0, fmt.Errorf("invalid payload size for message type %d: %d",
+			msg.Type(), len(buf))
+	}
+
+	addr, err := BytesToAddr(buf[offset+1 : offset+1+size])
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return addr.(*net.TCPAddr), size, nil
+}
+
+func checkSize(msg Message, buf []byte, size int) error {
+	if len(buf) != size {
+		return fmt.Errorf("invalid payload size for message type %d: %d, expected %d",
+			msg.Type(), len(buf), size)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package network
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"net"
@@ -10,49 +11,120 @@ import (
 const (
 	DataNodeJoin = iota
 	UserNodeJoin
-	PortForwarding
 	HealthCheck
 	ShutdownSig
+	RequestAccepted
+	ServerBusy
+	AlgoSwitch
+	FileRequest
+	FileResponse
+	Redirect
+	Heartbeat
 
-	ProtoTcp4       = "tcp4"
-	RandomLocalPort = "127.0.0.1:0"
+	// dual-stack, IPv4 addresses are dialed as is or IPv4-mapped
+	ProtoTcp = "tcp"
+)
+
+// encoded address sizes, [ip][port:2]
+const (
+	IPv4AddrSize = net.IPv4len + 2
+	IPv6AddrSize = net.IPv6len + 2
+)
+
+// file response status codes
+const (
+	StatusOK = iota
+	StatusNotFound
+	StatusDecryptFailed
+	StatusBusy
+	StatusInternal
+)
+
+var statusText = [...]string{
+	StatusOK:            "ok",
+	StatusNotFound:      "not-found",
+	StatusDecryptFailed: "decrypt-failed",
+	StatusBusy:          "busy",
+	StatusInternal:      "internal",
+}
+
+func StatusText(status uint8) string {
+	if int(status) >= len(statusText) {
+		return fmt.Sprintf("status-%d", status)
+	}
+	return statusText[status]
+}
+
+// priority classes, the lower the more urgent
+const (
+	PriorityInteractive = iota
+	PriorityBulk
+
+	PriorityClasses
 )
 
 var (
 	BinaryEndianess = binary.LittleEndian
 )
 
+// returns the number of bytes `addr` is encoded in. IPv4 and IPv4-mapped
+// addresses take 6 bytes, IPv6 addresses 18.
+func AddrSize(addr net.Addr) (int, error) {
+	addr_, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return 0, fmt.Errorf("returned type is not net.TCPAddr: %v", addr)
+	}
+
+	// the zone of a link-local address has no meaning to the peer
+	if addr_.Zone != "" {
+		return 0, fmt.Errorf("scoped address not supported: %v", addr)
+	}
+
+	if addr_.IP.To4() != nil {
+		return IPv4AddrSize, nil
+	}
+
+	if addr_.IP.To16() != nil {
+		return IPv6AddrSize, nil
+	}
+
+	return 0, fmt.Errorf("invalid ip address: %v", addr)
+}
+
 func AddrToBytes(addr net.Addr, buf []byte) error {
-	if len(buf) < 6 {
-		return fmt.Errorf("insufficient buf size")
+	size, err := AddrSize(addr)
+	if err != nil {
+		return err
 	}
 
-	addr_, ok := addr.(*net.TCPAddr)
-	if !ok {
-		return fmt.Errorf("returned type is not net.TCPAddr: %v", addr)
+	if len(buf) < size {
+		return fmt.Errorf("insufficient buf size")
 	}
 
+	addr_ := addr.(*net.TCPAddr)
 	ip := addr_.IP.To4()
 	if ip == nil {
-		return fmt.Errorf("returned type is not net.TCPAddr: %v", addr)
+		ip = addr_.IP.To16()
 	}
 
-	copy(buf[:4], ip)
+	copy(buf[:size-2], ip)
 
-	binary.LittleEndian.PutUint16(buf[4:6], uint16(addr_.Port))
+	binary.LittleEndian.PutUint16(buf[size-2:size], uint16(addr_.Port))
 
 	return nil
 }
 
-// parsing the first 6 bytes for `buf` only
+// the address family is told by the size of `buf`, either `IPv4AddrSize` or
+// `IPv6AddrSize`.
 func BytesToAddr(buf []byte) (net.Addr, error) {
-	if len(buf) < 6 {
-		return nil, fmt.Errorf("insufficient buf length")
+	if len(buf) != IPv4AddrSize && len(buf) != IPv6AddrSize {
+		return nil, fmt.Errorf("invalid address length: %d", len(buf))
 	}
 
+	size := len(buf)
 	addr := &net.TCPAddr{
-		IP:   buf[:4],
-		Port: int(binary.LittleEndian.Uint16(buf[4:6])),
+		IP:   bytes.Clone(buf[:size-2]),
+		Port: int(binary.LittleEndian.Uint16(buf[size-2 : size])),
 	}
 
 	return addr, nil
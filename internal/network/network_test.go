@@ -22,3 +22,30 @@ func TestAddrByteConv(t *testing.T) {
 	assert.Equal(t, addr.IP[3], buf[3])
 	assert.Equal(t, uint16(addr.Port), binary.LittleEndian.Uint16(buf[4:6]))
 }
+
+func TestAddrByteConvIPv6(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 8123}
+
+	size, err := AddrSize(addr)
+	assert.Nil(t, err)
+	assert.Equal(t, IPv6AddrSize, size)
+
+	buf := make([]byte, size)
+	assert.Nil(t, AddrToBytes(addr, buf))
+
+	got, err := BytesToAddr(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, addr.String(), got.String())
+
+	// IPv4-mapped addresses of a dual-stack socket are sent as IPv4
+	mapped := &net.TCPAddr{IP: net.ParseIP("::ffff:10.0.0.1"), Port: 80}
+	size, err = AddrSize(mapped)
+	assert.Nil(t, err)
+	assert.Equal(t, IPv4AddrSize, size)
+
+	assert.NotNil(t, AddrToBytes(addr, make([]byte, IPv4AddrSize)))
+	assert.NotNil(t, AddrToBytes(&net.TCPAddr{IP: net.ParseIP("fe80::1"), Zone: "eth0"}, buf))
+
+	_, err = BytesToAddr(buf[:7])
+	assert.NotNil(t, err)
+}
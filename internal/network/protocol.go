@@ -0,0 +1,140 @@
+package network
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Every message is sent in a frame:
+//
+//	[magic:2][version:1][type:1][payload length:4][payload]
+//
+// so messages are read whole, regardless of how TCP splits or coalesces the
+// segments.
+const (
+	Magic          uint16 = 0xd15c
+	Version        uint8  = 6 // bumped whenever a message layout changes
+	HeaderSize            = 2 + 1 + 1 + 4
+	MaxPayloadSize        = 1 << 16
+)
+
+var (
+	ErrBadMagic   = errors.New("bad magic number")
+	ErrBadVersion = errors.New("unsupported protocol version")
+)
+
+// Message is the payload of a frame.
+type Message interface {
+	Type() uint8
+	encoding.BinaryMarshaler
+	encoding.BinaryUnmarshaler
+}
+
+// returns the frame of `msg`.
+func Encode(msg Message) ([]byte, error) {
+	payload, err := msg.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(payload) > MaxPayloadSize {
+		return nil, fmt.Errorf("payload too large: %d bytes", len(payload))
+	}
+
+	frame := make([]byte, HeaderSize+len(payload))
+	BinaryEndianess.PutUint16(frame[0:2], Magic)
+	frame[2] = Version
+	frame[3] = msg.Type()
+	BinaryEndianess.PutUint32(frame[4:8], uint32(len(payload)))
+	copy(frame[HeaderSize:], payload)
+
+	return frame, nil
+}
+
+// writes the frame of `msg` in a single write.
+func Send(w io.Writer, msg Message) error {
+	frame, err := Encode(msg)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(frame)
+	return err
+}
+
+// reads the next frame. Returns io.EOF if the peer closed the connection
+// between two frames.
+func Receive(r io.Reader) (Message, error) {
+	var header [HeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	if BinaryEndianess.Uint16(header[0:2]) != Magic {
+		return nil, ErrBadMagic
+	}
+
+	if header[2] != Version {
+		return nil, fmt.Errorf("%w: %d", ErrBadVersion, header[2])
+	}
+
+	length := BinaryEndianess.Uint32(header[4:8])
+	if length > MaxPayloadSize {
+		return nil, fmt.Errorf("payload too large: %d bytes", length)
+	}
+
+	msg, err := newMessage(header[3])
+	if err != nil {
+		return nil, err
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	if err := msg.UnmarshalBinary(payload); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+func newMessage(msgType uint8) (Message, error) {
+	switch msgType {
+	case DataNodeJoin:
+		return &DataNodeJoinMsg{}, nil
+	case UserNodeJoin:
+		return &UserNodeJoinMsg{}, nil
+	case HealthCheck:
+		return &HealthCheckMsg{}, nil
+	case ShutdownSig:
+		return &ShutdownSigMsg{}, nil
+	case RequestAccepted:
+		return &RequestAcceptedMsg{}, nil
+	case ServerBusy:
+		return &ServerBusyMsg{}, nil
+	case AlgoSwitch:
+		return &AlgoSwitchMsg{}, nil
+	case FileRequest:
+		return &FileRequestMsg{}, nil
+	case FileResponse:
+		return &FileResponseMsg{}, nil
+	case Redirect:
+		return &RedirectMsg{}, nil
+	case Heartbeat:
+		return &HeartbeatMsg{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported message type: %d", msgType)
+	}
+}
+
+// the frame was cut short, a clean EOF is only expected between frames.
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,136 @@
+package network
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testMessages() []Message {
+	return []Message{
+		&DataNodeJoinMsg{NodeID: 3, Weight: 2, Capacity: 8},
+		&DataNodeJoinMsg{
+			NodeID: 4, Weight: 1, Capacity: 2, Heartbeat: 500 * time.Millisecond,
+			Addr: &net.TCPAddr{IP: net.ParseIP("fd00::2"), Port: 9000},
+		},
+		&UserNodeJoinMsg{
+			Addr:     &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1).To4(), Port: 8123},
+			Digest:   [32]byte{1, 2, 3},
+			ClientID: 0xdeadbeef,
+			Class:    PriorityBulk,
+		},
+		&UserNodeJoinMsg{
+			Addr:     &net.TCPAddr{IP: net.ParseIP("::1"), Port: 8123},
+			Digest:   [32]byte{1, 2, 3},
+			ClientID: 7,
+			Class:    PriorityInteractive,
+		},
+		&UserNodeJoinMsg{Addr: nil, Digest: [32]byte{1}, ClientID: 8},
+		&HealthCheckMsg{AvgRT: 1.5e6, Latency: 2 * time.Millisecond, Digest: [32]byte{4}, Status: StatusBusy},
+		&HeartbeatMsg{Seq: 42},
+		&ShutdownSigMsg{},
+		&RequestAcceptedMsg{},
+		&ServerBusyMsg{},
+		&AlgoSwitchMsg{Algorithm: "least-connection"},
+		&FileRequestMsg{Digest: [32]byte{5}, PubKey: [32]byte{6}},
+		&FileResponseMsg{Status: StatusOK, Length: 1 << 30},
+		&RedirectMsg{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2).To4(), Port: 9000}},
+		&FileResponseMsg{Status: StatusNotFound, Error: "no such file"},
+	}
+}
+
+func TestProtocolRoundTrip(t *testing.T) {
+	for _, msg := range testMessages() {
+		buf := new(bytes.Buffer)
+		assert.Nil(t, Send(buf, msg))
+
+		// the frames are read whole, however the bytes trickle in
+		got, err := Receive(iotest.OneByteReader(buf))
+		assert.Nil(t, err)
+		assert.Equal(t, msg, got)
+	}
+}
+
+func TestProtocolStream(t *testing.T) {
+	// back to back frames on a single connection
+	buf := new(bytes.Buffer)
+	for _, msg := range testMessages() {
+		assert.Nil(t, Send(buf, msg))
+	}
+
+	for _, msg := range testMessages() {
+		got, err := Receive(buf)
+		assert.Nil(t, err)
+		assert.Equal(t, msg, got)
+	}
+
+	_, err := Receive(buf)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestProtocolInvalid(t *testing.T) {
+	frame, err := Encode(&DataNodeJoinMsg{NodeID: 1})
+	assert.Nil(t, err)
+
+	corrupt := func(fn func([]byte)) []byte {
+		buf := bytes.Clone(frame)
+		fn(buf)
+		return buf
+	}
+
+	_, err = Receive(bytes.NewReader(corrupt(func(b []byte) { b[0] ^= 0xff })))
+	assert.True(t, errors.Is(err, ErrBadMagic))
+
+	_, err = Receive(bytes.NewReader(corrupt(func(b []byte) { b[2] = Version + 1 })))
+	assert.True(t, errors.Is(err, ErrBadVersion))
+
+	_, err = Receive(bytes.NewReader(corrupt(func(b []byte) { b[3] = 0xff })))
+	assert.NotNil(t, err)
+
+	_, err = Receive(bytes.NewReader(corrupt(func(b []byte) {
+		BinaryEndianess.PutUint32(b[4:8], MaxPayloadSize+1)
+	})))
+	assert.NotNil(t, err)
+
+	// the payload doesn't match the message type
+	_, err = Receive(bytes.NewReader(corrupt(func(b []byte) { b[3] = HealthCheck })))
+	assert.NotNil(t, err)
+
+	// cut short
+	_, err = Receive(bytes.NewReader(frame[:len(frame)-1]))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	_, err = Encode(&AlgoSwitchMsg{})
+	assert.NotNil(t, err)
+
+	_, err = Encode(&RedirectMsg{})
+	assert.NotNil(t, err)
+
+	_, err = Encode(&DataNodeJoinMsg{Heartbeat: -time.Second})
+	assert.NotNil(t, err)
+
+	// the address overruns the payload
+	frame, err = Encode(&UserNodeJoinMsg{})
+	assert.Nil(t, err)
+	frame[HeaderSize] = IPv6AddrSize
+	_, err = Receive(bytes.NewReader(frame))
+	assert.NotNil(t, err)
+}
+
+func TestFileResponseErr(t *testing.T) {
+	assert.Nil(t, (&FileResponseMsg{Status: StatusOK, Length: 10}).Err())
+
+	err := (&FileResponseMsg{Status: StatusDecryptFailed, Error: "bad tag"}).Err()
+	var statusErr *StatusError
+	assert.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, uint8(StatusDecryptFailed), statusErr.Status)
+	assert.Equal(t, "decrypt-failed: bad tag", err.Error())
+
+	assert.Equal(t, "status-200", StatusText(200))
+}
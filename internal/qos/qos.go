@@ -0,0 +1,140 @@
+// Package qos schedules requests by priority class. Class 0 is the most
+// urgent, requests of the same class are served in FIFO order.
+package qos
+
+import (
+	"fmt"
+)
+
+const (
+	// the most urgent non-empty class is always served first.
+	PolicyStrict = "strict"
+	// non-empty classes are served in proportion to their weights, so bulk
+	// requests can't be starved.
+	PolicyWeighted = "weighted"
+
+	// limit of a queue that never turns down a request.
+	Unbounded = -1
+)
+
+type Config struct {
+	Policy string `yaml:"policy"`
+	// share of each class, indexed by class, weighted only. Defaults to 1.
+	Weights []int `yaml:"weights"`
+}
+
+// Queue is a bounded multi-class FIFO, not thread safe.
+type Queue[T any] struct {
+	classes [][]T
+	weights []int
+	current []int // smooth weighted round robin state
+	strict  bool
+	size    int
+	limit   int
+}
+
+func NewQueue[T any](classes int, conf Config, limit int) (*Queue[T], error) {
+	if classes <= 0 {
+		return nil, fmt.Errorf("invalid number of classes: %d.", classes)
+	}
+
+	if len(conf.Weights) > classes {
+		return nil, fmt.Errorf("expected at most %d weights, got %d.",
+			classes, len(conf.Weights))
+	}
+
+	q := &Queue[T]{
+		classes: make([][]T, classes),
+		weights: make([]int, classes),
+		current: make([]int, classes),
+		limit:   limit,
+	}
+
+	for i := range q.weights {
+		q.weights[i] = 1
+		if i < len(conf.Weights) && conf.Weights[i] > 0 {
+			q.weights[i] = conf.Weights[i]
+		}
+	}
+
+	switch conf.Policy {
+	case "", PolicyStrict:
+		q.strict = true
+	case PolicyWeighted:
+		q.strict = false
+	default:
+		return nil, fmt.Errorf("unsupported scheduling policy [%s].", conf.Policy)
+	}
+
+	return q, nil
+}
+
+// returns false if the queue is full. Classes out of range are queued as the
+// least urgent class.
+func (q *Queue[T]) Push(class int, item T) bool {
+	if q.limit != Unbounded && q.size >= q.limit {
+		return false
+	}
+
+	class = min(max(class, 0), len(q.classes)-1)
+	q.classes[class] = append(q.classes[class], item)
+	q.size += 1
+	return true
+}
+
+// puts an item just popped back at the head of its class, the limit doesn't
+// apply.
+func (q *Queue[T]) PushFront(class int, item T) {
+	class = min(max(class, 0), len(q.classes)-1)
+	q.classes[class] = append([]T{item}, q.classes[class]...)
+	q.size += 1
+}
+
+// returns false if the queue is empty.
+func (q *Queue[T]) Pop() (T, bool) {
+	var item T
+	if q.size == 0 {
+		return item, false
+	}
+
+	class := q.next()
+	item = q.classes[class][0]
+
+	var zero T
+	q.classes[class][0] = zero
+	q.classes[class] = q.classes[class][1:]
+	q.size -= 1
+
+	// an idle class starts over once it's backlogged again
+	if len(q.classes[class]) == 0 {
+		q.current[class] = 0
+	}
+	return item, true
+}
+
+func (q *Queue[T]) Len() int {
+	return q.size
+}
+
+// the class served next, the queue isn't empty.
+func (q *Queue[T]) next() int {
+	best, total := -1, 0
+	for class, items := range q.classes {
+		if len(items) == 0 {
+			continue
+		}
+
+		if q.strict {
+			return class
+		}
+
+		q.current[class] += q.weights[class]
+		total += q.weights[class]
+		if best == -1 || q.current[class] > q.current[best] {
+			best = class
+		}
+	}
+
+	q.current[best] -= total
+	return best
+}
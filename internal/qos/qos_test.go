@@ -0,0 +1,97 @@
+package qos
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func popAll(q *Queue[int]) []int {
+	items := make([]int, 0)
+	for {
+		item, ok := q.Pop()
+		if !ok {
+			return items
+		}
+		items = append(items, item)
+	}
+}
+
+func TestQueueStrict(t *testing.T) {
+	q, err := NewQueue[int](3, Config{}, Unbounded)
+	assert.Nil(t, err)
+
+	// class 2 is queued first, but served last
+	assert.True(t, q.Push(2, 20))
+	assert.True(t, q.Push(1, 10))
+	assert.True(t, q.Push(0, 1))
+	assert.True(t, q.Push(1, 11))
+	assert.True(t, q.Push(0, 2))
+
+	// out of range classes are the least urgent
+	assert.True(t, q.Push(7, 21))
+	assert.True(t, q.Push(-1, 3))
+
+	assert.Equal(t, 7, q.Len())
+	assert.Equal(t, []int{1, 2, 3, 10, 11, 20, 21}, popAll(q))
+	assert.Equal(t, 0, q.Len())
+}
+
+func TestQueueWeighted(t *testing.T) {
+	q, err := NewQueue[int](2, Config{Policy: PolicyWeighted, Weights: []int{3, 1}}, Unbounded)
+	assert.Nil(t, err)
+
+	for i := range 8 {
+		q.Push(0, i)
+		q.Push(1, 100+i)
+	}
+
+	// 3 interactive for every bulk request, while both are backlogged
+	served := popAll(q)
+	assert.Equal(t, []int{0, 1, 100, 2, 3, 4, 101, 5}, served[:8])
+	assert.Equal(t, 16, len(served))
+
+	// an empty class doesn't bank its share
+	q.Push(1, 100)
+	q.Push(1, 101)
+	q.Push(0, 0)
+	assert.Equal(t, []int{0, 100, 101}, popAll(q))
+}
+
+func TestQueueLimit(t *testing.T) {
+	q, err := NewQueue[int](2, Config{}, 2)
+	assert.Nil(t, err)
+
+	assert.True(t, q.Push(1, 1))
+	assert.True(t, q.Push(1, 2))
+	assert.False(t, q.Push(0, 0))
+
+	item, ok := q.Pop()
+	assert.True(t, ok)
+	assert.Equal(t, 1, item)
+	assert.True(t, q.Push(0, 0))
+
+	// an item put back is served first, even over the limit
+	item, ok = q.Pop()
+	assert.True(t, ok)
+	assert.True(t, q.Push(1, 3))
+	q.PushFront(0, item)
+	assert.Equal(t, 3, q.Len())
+	assert.Equal(t, []int{0, 2, 3}, popAll(q))
+
+	// a queue of size 0 turns everything down
+	q, err = NewQueue[int](2, Config{}, 0)
+	assert.Nil(t, err)
+	assert.False(t, q.Push(0, 0))
+}
+
+func TestQueueInvalid(t *testing.T) {
+	_, err := NewQueue[int](0, Config{}, 0)
+	assert.NotNil(t, err)
+
+	_, err = NewQueue[int](2, Config{Policy: "lottery"}, 0)
+	assert.NotNil(t, err)
+
+	_, err = NewQueue[int](2, Config{Policy: PolicyWeighted, Weights: []int{1, 2, 3}}, 0)
+	assert.NotNil(t, err)
+}
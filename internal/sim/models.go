@@ -0,0 +1,86 @@
+package sim
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Arrivals schedules when the requests of a run arrive at the load balancer.
+type Arrivals interface {
+	// returns `n` arrival times, relative to the start of the run.
+	Schedule(rng *rand.Rand, n int) []time.Duration
+}
+
+// ServiceTime models how long a node takes to serve a request, once one of
+// its slots is free.
+type ServiceTime interface {
+	Sample(rng *rand.Rand, size uint64) time.Duration
+}
+
+// Poisson arrivals, `Rate` requests per second on average.
+type Poisson struct {
+	Rate float64
+}
+
+func (p Poisson) Schedule(rng *rand.Rand, n int) []time.Duration {
+	times := make([]time.Duration, n)
+	t := 0.0
+	for i := range times {
+		t += rng.ExpFloat64() / p.Rate
+		times[i] = time.Duration(t * float64(time.Second))
+	}
+	return times
+}
+
+// Constant arrivals, one request every `Interval`.
+type Constant struct {
+	Interval time.Duration
+}
+
+func (c Constant) Schedule(_ *rand.Rand, n int) []time.Duration {
+	times := make([]time.Duration, n)
+	for i := range times {
+		times[i] = time.Duration(i) * c.Interval
+	}
+	return times
+}
+
+// Uniform arrivals, every request arrives at a uniformly random time within
+// `Window`. This is how the user simulation staggers its requests.
+type Uniform struct {
+	Window time.Duration
+}
+
+func (u Uniform) Schedule(rng *rand.Rand, n int) []time.Duration {
+	times := make([]time.Duration, n)
+	for i := range times {
+		times[i] = time.Duration(rng.Int63n(int64(u.Window) + 1))
+	}
+	return times
+}
+
+// Exponential service times with mean `Mean`, regardless of the file size.
+type Exponential struct {
+	Mean time.Duration
+}
+
+func (e Exponential) Sample(rng *rand.Rand, _ uint64) time.Duration {
+	return time.Duration(rng.ExpFloat64() * float64(e.Mean))
+}
+
+// Linear service times: a fixed `Overhead`, plus the file transferred at
+// `Bandwidth` bytes per second. This mirrors the data nodes, which sleep for
+// their overhead before streaming the file. The transfer is free if
+// `Bandwidth` is 0.
+type Linear struct {
+	Overhead  time.Duration
+	Bandwidth float64
+}
+
+func (l Linear) Sample(_ *rand.Rand, size uint64) time.Duration {
+	if l.Bandwidth <= 0 {
+		return l.Overhead
+	}
+	transfer := float64(size) / l.Bandwidth * float64(time.Second)
+	return l.Overhead + time.Duration(transfer)
+}
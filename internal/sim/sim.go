@@ -0,0 +1,383 @@
+// Package sim is a discrete-event simulator for the load balancing
+// algorithms. Requests arrive at a virtual load balancer, are dispatched to
+// virtual data nodes by the algorithm and served in virtual time, so runs
+// take milliseconds instead of minutes. The events are recorded with the same
+// CSV columns as the load balancer.
+//
+// The load balancer and the network are assumed to be instant: a request
+// reaches its node as soon as it arrives, and the node reports back as soon
+// as it's done.
+package sim
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/hn275/distributed-storage/internal"
+	"github.com/hn275/distributed-storage/internal/algo"
+	"github.com/hn275/distributed-storage/internal/network"
+	"github.com/hn275/distributed-storage/internal/qos"
+	"github.com/hn275/distributed-storage/internal/telemetry"
+)
+
+// Epoch is the wall clock time virtual time starts at, in the telemetry.
+var Epoch = time.Unix(0, 0)
+
+// Collector receives the load balancer events of a run, implemented by
+// *telemetry.Telemetry.
+type Collector interface {
+	Collect(telemetry.Record)
+}
+
+type Config struct {
+	// initialized, without any node.
+	Engine   algo.LBAlgo
+	Nodes    []Node
+	Requests []Request
+	Arrivals Arrivals
+	// order the requests waiting at a node are served in.
+	Scheduler qos.Config
+	// seeds the arrivals and the service times, randomized algorithms have
+	// their own source.
+	Seed int64
+}
+
+// Node is a simulated data node.
+type Node struct {
+	ID     uint16
+	Weight uint16
+	// number of requests served concurrently, the others wait at the node,
+	// picked by priority class. 0 is unbounded.
+	Capacity uint16
+	Service  ServiceTime
+}
+
+// Request is a file requested by a user.
+type Request struct {
+	Key    []byte // file digest, routed on by keyed algorithms
+	Size   uint64
+	Client uint64 // routed on by client aware algorithms
+	Class  uint8  // priority class
+}
+
+type Result struct {
+	// response times as seen by the users, sorted.
+	Latencies []time.Duration
+	// number of requests served by each node.
+	Served map[uint16]int
+	// time the last request completed.
+	Makespan time.Duration
+	// the same, for the requests of each priority class.
+	Classes map[uint8]*Result
+}
+
+// Mean response time.
+func (r *Result) Mean() time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+
+	var sum time.Duration
+	for _, latency := range r.Latencies {
+		sum += latency
+	}
+	return sum / time.Duration(len(r.Latencies))
+}
+
+// Percentile of the response times, `p` in [0, 100], nearest rank.
+func (r *Result) Percentile(p float64) time.Duration {
+	n := len(r.Latencies)
+	if n == 0 {
+		return 0
+	}
+
+	rank := int(math.Ceil(p / 100 * float64(n)))
+	return r.Latencies[min(max(rank-1, 0), n-1)]
+}
+
+// Run simulates the requests of `conf` through its algorithm, until all of
+// them are served. Events are sent to `tel`, which may be nil.
+func Run(conf Config, tel Collector) (*Result, error) {
+	if conf.Engine == nil || conf.Arrivals == nil {
+		return nil, errors.New("missing engine or arrival process.")
+	}
+	if len(conf.Nodes) == 0 {
+		return nil, errors.New("no data node.")
+	}
+
+	s := &simulation{
+		Config: conf,
+		tel:    tel,
+		rng:    rand.New(rand.NewSource(conf.Seed)),
+		nodes:  make([]*node, len(conf.Nodes)),
+		events: make(eventQueue, 0, len(conf.Requests)),
+		result: newResult(len(conf.Requests)),
+	}
+
+	for i, n := range conf.Nodes {
+		if n.Service == nil {
+			return nil, fmt.Errorf("no service time model for node %d.", n.ID)
+		}
+
+		waiting, err := qos.NewQueue[*request](
+			network.PriorityClasses, conf.Scheduler, qos.Unbounded)
+		if err != nil {
+			return nil, err
+		}
+
+		s.nodes[i] = &node{conf: n, index: -1, waiting: waiting}
+		s.Engine.NodeJoin(s.nodes[i])
+		s.record(telemetry.LBNodeJoin, telemetry.PeerDataNode, s.nodes[i])
+	}
+
+	times := conf.Arrivals.Schedule(s.rng, len(conf.Requests))
+	for i := range conf.Requests {
+		s.push(&event{at: times[i], req: &request{Request: conf.Requests[i]}})
+	}
+
+	for s.events.Len() != 0 {
+		e := heap.Pop(&s.events).(*event)
+		s.now = e.at
+
+		var err error
+		if e.done {
+			err = s.complete(e.req)
+		} else {
+			err = s.arrive(e.req)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s.result.done()
+	return s.result, nil
+}
+
+func newResult(requests int) *Result {
+	return &Result{
+		Latencies: make([]time.Duration, 0, requests),
+		Served:    make(map[uint16]int),
+		Classes:   make(map[uint8]*Result),
+	}
+}
+
+func (r *Result) add(req *request, now time.Duration) {
+	r.Latencies = append(r.Latencies, now-req.arrival)
+	r.Served[req.node.conf.ID] += 1
+	r.Makespan = now
+}
+
+func (r *Result) done() {
+	slices.Sort(r.Latencies)
+	for _, class := range r.Classes {
+		class.done()
+	}
+}
+
+type simulation struct {
+	Config
+	tel    Collector
+	rng    *rand.Rand
+	nodes  []*node
+	events eventQueue
+	seq    uint64
+	now    time.Duration
+	result *Result
+}
+
+type request struct {
+	Request
+	arrival time.Duration
+	node    *node
+}
+
+// dispatches the request, as the load balancer does on a user join.
+func (s *simulation) arrive(req *request) error {
+	req.arrival = s.now
+
+	var (
+		qn  algo.QueueNode
+		err error
+	)
+
+	if sticky, ok := s.Engine.(algo.ClientLBAlgo); ok {
+		qn, err = sticky.GetNodeByClient(req.Client, req.Key)
+	} else if keyed, ok := s.Engine.(algo.KeyedLBAlgo); ok {
+		qn, err = keyed.GetNodeByKey(req.Key)
+	} else {
+		qn, err = s.Engine.GetNode()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	n := qn.(*node)
+	n.active += 1
+	s.Engine.PutNode(n)
+	s.record(telemetry.LBUserJoin, telemetry.PeerUser, n)
+
+	req.node = n
+	if n.conf.Capacity == 0 || n.busy < int(n.conf.Capacity) {
+		s.serve(req)
+	} else {
+		n.waiting.Push(int(req.Class), req)
+	}
+
+	return nil
+}
+
+func (s *simulation) serve(req *request) {
+	req.node.busy += 1
+	service := req.node.conf.Service.Sample(s.rng, req.Size)
+	s.push(&event{at: s.now + max(service, 0), req: req, done: true})
+}
+
+// the node reports back, as the load balancer does on a health check.
+func (s *simulation) complete(req *request) error {
+	n := req.node
+	latency := s.now - req.arrival
+
+	// same moving average as the data nodes
+	n.avgRT = internal.CalcMovingAvg(n.served, n.avgRT, float64(latency))
+	n.served += 1
+	n.busy -= 1
+	n.active -= 1
+
+	if completer, ok := s.Engine.(algo.Completer); ok {
+		completer.Complete(n, req.Key)
+	}
+	if observer, ok := s.Engine.(algo.Observer); ok {
+		observer.Observe(n, req.Key, latency)
+	}
+
+	if err := s.Engine.Fix(n.index); err != nil {
+		return err
+	}
+
+	s.record(telemetry.LBHealthCheck, telemetry.PeerDataNode, n)
+
+	s.result.add(req, s.now)
+	class, ok := s.result.Classes[req.Class]
+	if !ok {
+		class = newResult(0)
+		s.result.Classes[req.Class] = class
+	}
+	class.add(req, s.now)
+
+	if next, ok := n.waiting.Pop(); ok {
+		s.serve(next)
+	}
+
+	return nil
+}
+
+func (s *simulation) record(eType, peer string, n *node) {
+	if s.tel == nil {
+		return
+	}
+
+	s.tel.Collect(&telemetry.LBEvent{
+		Type:      eType,
+		Peer:      peer,
+		PeerID:    int32(n.conf.ID),
+		Timestamp: Epoch.Add(s.now),
+		Duration:  0,
+		AvgRT:     n.avgRT,
+		ActiveReq: n.active,
+		Queue:     telemetry.QueueString(s.Engine.Snapshot()),
+	})
+}
+
+func (s *simulation) push(e *event) {
+	e.seq = s.seq
+	s.seq += 1
+	heap.Push(&s.events, e)
+}
+
+// node implements algo.QueueNode, algo.Weighted and algo.Capacitated.
+type node struct {
+	net.Conn // nil, the algorithms never use the socket
+
+	conf    Node
+	index   int
+	active  uint64 // dispatched, not completed yet
+	avgRT   float64
+	served  uint64
+	busy    int
+	waiting *qos.Queue[*request]
+}
+
+func (n *node) SetIndex(i int) {
+	n.index = i
+}
+
+func (n *node) Index() int {
+	return n.index
+}
+
+func (n *node) ID() uint16 {
+	return n.conf.ID
+}
+
+func (n *node) ActiveRequests() uint64 {
+	return n.active
+}
+
+func (n *node) AvgResponseTime() float64 {
+	return n.avgRT
+}
+
+func (n *node) Weight() uint16 {
+	return n.conf.Weight
+}
+
+func (n *node) Capacity() uint16 {
+	return n.conf.Capacity
+}
+
+type event struct {
+	at   time.Duration
+	seq  uint64 // ties are broken in scheduling order
+	req  *request
+	done bool // completion, arrival otherwise
+}
+
+// min heap on the event time.
+type eventQueue []*event
+
+func (q eventQueue) Len() int {
+	return len(q)
+}
+
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at == q[j].at {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].at < q[j].at
+}
+
+func (q eventQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *eventQueue) Push(x any) {
+	*q = append(*q, x.(*event))
+}
+
+func (q *eventQueue) Pop() any {
+	old := *q
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return e
+}
@@ -0,0 +1,188 @@
+package sim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hn275/distributed-storage/internal/algo"
+	"github.com/hn275/distributed-storage/internal/network"
+	"github.com/hn275/distributed-storage/internal/qos"
+	"github.com/hn275/distributed-storage/internal/telemetry"
+	"github.com/stretchr/testify/assert"
+)
+
+type testCollector struct {
+	events []*telemetry.LBEvent
+}
+
+func (c *testCollector) Collect(record telemetry.Record) {
+	c.events = append(c.events, record.(*telemetry.LBEvent))
+}
+
+func makeTestConfig(name string, nodeCount int, service ServiceTime) Config {
+	engine, err := algo.New(name, algo.Options{
+		Catalog: func() (map[string]uint64, error) {
+			return map[string]uint64{}, nil
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	nodes := make([]Node, nodeCount)
+	for i := range nodes {
+		nodes[i] = Node{ID: uint16(i), Weight: 1, Capacity: 1, Service: service}
+	}
+
+	return Config{Engine: engine, Nodes: nodes, Seed: 1}
+}
+
+func makeTestRequests(n int) []Request {
+	requests := make([]Request, n)
+	for i := range requests {
+		requests[i] = Request{Key: []byte{byte(i), byte(i >> 8)}, Size: 1 << 10}
+	}
+	return requests
+}
+
+func TestRunCapacity(t *testing.T) {
+	// a request every 10ms, served in 25ms, alternating between 2 nodes of
+	// capacity 1: every other request waits 5ms for the node to free up
+	conf := makeTestConfig(algo.AlgoSimpleRoundRobin, 2,
+		Linear{Overhead: 25 * time.Millisecond})
+	conf.Requests = makeTestRequests(4)
+	conf.Arrivals = Constant{10 * time.Millisecond}
+
+	result, err := Run(conf, nil)
+	assert.Nil(t, err)
+
+	ms := time.Millisecond
+	assert.Equal(t, []time.Duration{25 * ms, 25 * ms, 30 * ms, 30 * ms}, result.Latencies)
+	assert.Equal(t, 60*ms, result.Makespan)
+	assert.Equal(t, map[uint16]int{0: 2, 1: 2}, result.Served)
+	assert.Equal(t, 27500*time.Microsecond, result.Mean())
+	assert.Equal(t, 25*ms, result.Percentile(50))
+	assert.Equal(t, 30*ms, result.Percentile(99))
+}
+
+func TestRunTelemetry(t *testing.T) {
+	conf := makeTestConfig(algo.AlgoLeastConnections, 3,
+		Exponential{10 * time.Millisecond})
+	conf.Requests = makeTestRequests(50)
+	conf.Arrivals = Poisson{Rate: 200}
+
+	tel := &testCollector{}
+	_, err := Run(conf, tel)
+	assert.Nil(t, err)
+
+	counts := make(map[string]int)
+	last := Epoch
+	for _, e := range tel.events {
+		counts[e.Type]++
+		assert.Equal(t, len(telemetry.LBHeaders), len(e.Row()))
+		assert.False(t, e.Timestamp.Before(last))
+		last = e.Timestamp
+	}
+
+	assert.Equal(t, map[string]int{
+		telemetry.LBNodeJoin:    3,
+		telemetry.LBUserJoin:    50,
+		telemetry.LBHealthCheck: 50,
+	}, counts)
+
+	// all requests are done by the end of the run
+	for _, n := range conf.Engine.Snapshot() {
+		assert.Equal(t, uint64(0), n.Active)
+	}
+}
+
+func TestRunDeterministic(t *testing.T) {
+	// same seed, same run, as long as the algorithm isn't randomized
+	run := func() *Result {
+		conf := makeTestConfig(algo.AlgoLeastConnections, 4,
+			Exponential{20 * time.Millisecond})
+		conf.Requests = makeTestRequests(200)
+		conf.Arrivals = Poisson{Rate: 100}
+
+		result, err := Run(conf, nil)
+		assert.Nil(t, err)
+		return result
+	}
+
+	assert.Equal(t, run(), run())
+}
+
+func TestRunAlgorithms(t *testing.T) {
+	// every algorithm serves every request
+	for _, name := range algo.Registered() {
+		conf := makeTestConfig(name, 5, Linear{
+			Overhead:  5 * time.Millisecond,
+			Bandwidth: 1 << 20,
+		})
+		conf.Requests = makeTestRequests(500)
+		conf.Arrivals = Uniform{time.Second}
+
+		result, err := Run(conf, nil)
+		assert.Nil(t, err, name)
+		assert.Equal(t, 500, len(result.Latencies), name)
+	}
+}
+
+func TestRunPriority(t *testing.T) {
+	run := func(scheduler qos.Config, bulk uint8) *Result {
+		// a single slot, bulk requests take ~100ms, interactive ones ~2ms
+		conf := makeTestConfig(algo.AlgoLeastConnections, 1, Linear{
+			Overhead:  time.Millisecond,
+			Bandwidth: 1 << 20,
+		})
+		conf.Scheduler = scheduler
+		conf.Arrivals = Constant{10 * time.Millisecond}
+
+		// alternating bulk and interactive requests, the node falls behind
+		conf.Requests = makeTestRequests(40)
+		for i := range conf.Requests {
+			if i%2 == 0 {
+				conf.Requests[i].Size = 100 << 10
+				conf.Requests[i].Class = bulk
+			}
+		}
+
+		result, err := Run(conf, nil)
+		assert.Nil(t, err)
+		return result
+	}
+
+	// every request in the same class, that's FIFO
+	fifo := run(qos.Config{}, network.PriorityInteractive)
+	assert.Equal(t, 1, len(fifo.Classes))
+	assert.Greater(t, fifo.Percentile(99), time.Second)
+
+	// interactive requests wait for the bulk request in service at most
+	strict := run(qos.Config{}, network.PriorityBulk)
+	interactive := strict.Classes[network.PriorityInteractive]
+	assert.Equal(t, 20, len(interactive.Latencies))
+	assert.Less(t, interactive.Percentile(99), 150*time.Millisecond)
+	assert.Equal(t, fifo.Makespan, strict.Makespan)
+
+	// bulk requests get their share under weighted fair queueing
+	weighted := run(qos.Config{Policy: qos.PolicyWeighted, Weights: []int{1, 1}},
+		network.PriorityBulk)
+	assert.Greater(t,
+		weighted.Classes[network.PriorityInteractive].Percentile(99),
+		interactive.Percentile(99))
+	assert.Less(t,
+		weighted.Classes[network.PriorityBulk].Percentile(99),
+		strict.Classes[network.PriorityBulk].Percentile(99))
+}
+
+func TestRunInvalid(t *testing.T) {
+	conf := makeTestConfig(algo.AlgoLeastConnections, 0, nil)
+	conf.Arrivals = Constant{time.Millisecond}
+	_, err := Run(conf, nil)
+	assert.NotNil(t, err)
+
+	conf = makeTestConfig(algo.AlgoLeastConnections, 1, nil)
+	conf.Arrivals = Constant{time.Millisecond}
+	_, err = Run(conf, nil)
+	assert.NotNil(t, err)
+}
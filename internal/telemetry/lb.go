@@ -0,0 +1,87 @@
+package telemetry
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hn275/distributed-storage/internal/algo"
+)
+
+// load balancer events, shared by the load balancer and the simulator so
+// both output the same CSV.
+const (
+	LBUserJoin    = "user-joined"
+	LBNodeJoin    = "node-joined"
+	LBNodeLeave   = "node-left"
+	LBPortForward = "port-forward"
+	LBHealthCheck = "health-check"
+	LBQueued      = "request-queued"
+	LBDequeued    = "request-dequeued"
+	LBRejected    = "request-rejected"
+	LBAlgoSwitch  = "algo-switch"
+	LBRelay       = "relay"
+	// the redirected user was never reported on by the data node
+	LBRedirectExpired = "redirect-expired"
+
+	// failure detection, a suspected node is taken out of scheduling until
+	// it recovers, a dead node is disconnected
+	LBNodeSuspect   = "node-suspect"
+	LBNodeRecovered = "node-recovered"
+	LBNodeDead      = "node-dead"
+
+	PeerUser     = "user"
+	PeerDataNode = "node"
+	PeerAdmin    = "admin"
+)
+
+var LBHeaders = []string{
+	"event-type",
+	"peer",
+	"node-id",
+	"timestamp",
+	"duration(ns)",
+	"avgRT(ns)",
+	"active-requests",
+	"queue",
+	"bytes",
+}
+
+// LBEvent implements Record.
+type LBEvent struct {
+	Type      string
+	Peer      string
+	PeerID    int32
+	Timestamp time.Time
+	Duration  int64
+	AvgRT     float64
+	ActiveReq uint64
+	Queue     string
+	Bytes     uint64 // relayed, relay mode only
+}
+
+func (e *LBEvent) Row() []string {
+	return []string{
+		e.Type,
+		e.Peer,
+		fmt.Sprintf("%d", e.PeerID),
+		fmt.Sprintf("%d", e.Timestamp.UnixNano()),
+		fmt.Sprintf("%d", e.Duration),
+		fmt.Sprintf("%f", e.AvgRT),
+		fmt.Sprintf("%d", e.ActiveReq),
+		e.Queue,
+		fmt.Sprintf("%d", e.Bytes),
+	}
+}
+
+// formats an algorithm's snapshot as "(id,active-requests,score)", in the
+// algorithm's order.
+func QueueString(states []algo.NodeState) string {
+	s := make([]string, len(states))
+
+	for i, state := range states {
+		s[i] = fmt.Sprintf("(%d,%d,%g)", state.ID, state.Active, state.Score)
+	}
+
+	return strings.Join(s, ", ")
+}
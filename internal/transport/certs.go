@@ -0,0 +1,136 @@
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	caFile = "ca.pem"
+
+	// how long the certificates generated are valid for
+	certValidity = 365 * 24 * time.Hour
+)
+
+func certFile(role string) string {
+	return role + ".pem"
+}
+
+func keyFile(role string) string {
+	return role + "-key.pem"
+}
+
+// generates a CA, and a certificate signed by it for each of `Roles`, into
+// `dir`. The CA key isn't kept, the certificates are generated all at once.
+func GenerateCerts(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	caTemplate, err := newTemplate("distributed-storage CA")
+	if err != nil {
+		return err
+	}
+	caTemplate.IsCA = true
+	caTemplate.BasicConstraintsValid = true
+	caTemplate.KeyUsage = x509.KeyUsageCertSign
+
+	caDER, err := x509.CreateCertificate(
+		rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return err
+	}
+
+	if err := writePEM(filepath.Join(dir, caFile), "CERTIFICATE", caDER, 0644); err != nil {
+		return err
+	}
+
+	for _, role := range Roles {
+		if err := generateCert(dir, role, caCert, caKey); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// the role is both the subject and the name of the certificate, the nodes and
+// users are servers as well as clients.
+func generateCert(dir, role string, ca *x509.Certificate, caKey *ecdsa.PrivateKey) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	template, err := newTemplate(role)
+	if err != nil {
+		return err
+	}
+	template.DNSNames = []string{role}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{
+		x509.ExtKeyUsageServerAuth,
+		x509.ExtKeyUsageClientAuth,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := writePEM(filepath.Join(dir, certFile(role)), "CERTIFICATE", der, 0644); err != nil {
+		return err
+	}
+	return writePEM(filepath.Join(dir, keyFile(role)), "PRIVATE KEY", keyDER, 0600)
+}
+
+func newTemplate(commonName string) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(certValidity),
+	}, nil
+}
+
+func writePEM(path, blockType string, der []byte, perm os.FileMode) error {
+	fd, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if err := pem.Encode(fd, &pem.Block{Type: blockType, Bytes: der}); err != nil {
+		fd.Close()
+		return err
+	}
+	return fd.Close()
+}
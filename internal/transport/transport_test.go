@@ -0,0 +1,91 @@
+package transport
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/hn275/distributed-storage/internal/network"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTransport(t *testing.T, certDir, role string) *Transport {
+	tr, err := New(certDir, role)
+	assert.Nil(t, err)
+	return tr
+}
+
+// sends a message from the client to the server, returns the error of the
+// server's authorization and of the exchange.
+func exchange(client, server *Transport, peer string, roles ...string) (error, error) {
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+
+	clientConn, serverConn := client.Client(c, peer), server.Server(s)
+
+	errc := make(chan error, 1)
+	go func() {
+		err := network.Send(clientConn, &network.ShutdownSigMsg{})
+		if err != nil {
+			c.Close()
+		}
+		errc <- err
+	}()
+
+	authErr := server.Authorize(serverConn, roles...)
+	if authErr != nil {
+		s.Close()
+		return authErr, <-errc
+	}
+
+	_, err := network.Receive(serverConn)
+	return nil, errors.Join(err, <-errc)
+}
+
+func TestTransportMutualAuth(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, GenerateCerts(dir))
+
+	lb := newTransport(t, dir, RoleLB)
+	node := newTransport(t, dir, RoleNode)
+	admin := newTransport(t, dir, RoleAdmin)
+
+	authErr, err := exchange(node, lb, RoleLB, RoleNode)
+	assert.Nil(t, authErr)
+	assert.Nil(t, err)
+
+	// an admin can't join as a data node
+	authErr, _ = exchange(admin, lb, RoleLB, RoleNode)
+	assert.True(t, errors.Is(authErr, ErrUnauthorized))
+
+	// the node isn't who the client expects
+	authErr, err = exchange(lb, node, RoleUser, RoleLB)
+	assert.NotNil(t, authErr)
+	assert.NotNil(t, err)
+}
+
+func TestTransportUnknownCA(t *testing.T) {
+	dir, otherDir := t.TempDir(), t.TempDir()
+	assert.Nil(t, GenerateCerts(dir))
+	assert.Nil(t, GenerateCerts(otherDir))
+
+	lb := newTransport(t, dir, RoleLB)
+	node := newTransport(t, otherDir, RoleNode)
+
+	authErr, err := exchange(node, lb, RoleLB, RoleNode)
+	assert.NotNil(t, authErr)
+	assert.NotNil(t, err)
+}
+
+func TestTransportPlain(t *testing.T) {
+	authErr, err := exchange(Plain(), Plain(), RoleLB, RoleNode)
+	assert.Nil(t, authErr)
+	assert.Nil(t, err)
+
+	// a plaintext peer can't talk to a secure one
+	dir := t.TempDir()
+	assert.Nil(t, GenerateCerts(dir))
+	authErr, _ = exchange(Plain(), newTransport(t, dir, RoleLB), RoleLB, RoleNode)
+	assert.NotNil(t, authErr)
+}